@@ -14,6 +14,7 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
 	"github.com/jialeicui/keyswift/pkg/evdev"
 	"github.com/jialeicui/keyswift/pkg/handler"
 	"github.com/jialeicui/keyswift/pkg/utils"
@@ -79,12 +80,13 @@ func main() {
 	}
 
 	// Initialize bus manager
-	busMgr, err := bus.New(string(script), windowMonitor, out)
+	busMgr, err := bus.New(string(script), windowMonitor, out, engine.WithScriptPath(configPath))
 	if err != nil {
 		slog.Error("Failed to initialize bus manager", "error", err)
 		os.Exit(1)
 	}
 	slog.Info("bus manager initialized")
+	go watchConfig(configPath, busMgr)
 
 	// Find input devices
 	devs, err := evdev.NewOverviewImpl().ListInputDevices()
@@ -171,6 +173,7 @@ func main() {
 		<-sigChan
 		slog.Info("Shutting down...")
 		deviceManager.Close()
+		busMgr.Close()
 		out.Close()
 		windowMonitor.Close()
 		os.Exit(0)
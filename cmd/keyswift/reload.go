@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
+)
+
+// configPollInterval is how often the config files are checked for changes
+const configPollInterval = time.Second
+
+// fileStamp tells a file changed, the size catches the writes within the resolution of the modification time
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchConfig reloads the config when the file or one of the modules it imports changes.
+// The running config is kept if the new one fails to load.
+func watchConfig(path string, busMgr *bus.Impl) {
+	files := engine.ModuleFiles(path)
+	stamps := fileStamps(files)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if maps.Equal(stamps, fileStamps(files)) {
+			continue
+		}
+		// the imports may have changed as well
+		files = engine.ModuleFiles(path)
+		stamps = fileStamps(files)
+
+		script, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("Failed to read configuration file", "error", err)
+			continue
+		}
+		if err = busMgr.Reload(string(script)); err != nil {
+			slog.Error("Failed to reload configuration, keeping the running one", "error", err)
+			continue
+		}
+		slog.Info("Configuration reloaded", "files", files)
+	}
+}
+
+// fileStamps returns the stamps of the files, the missing ones are left out
+func fileStamps(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			stamps[f] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
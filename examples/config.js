@@ -1,10 +1,80 @@
 /**
  * @typedef {Object} KeySwift
  * @property {function(): string} getActiveWindowClass
+ * getActiveWindowClass should be called inside callbacks, the script itself is evaluated only once at startup
+ * @property {function(): Window|null} getActiveWindow
+ * @property {function({class: Pattern|[Pattern], title: Pattern|[Pattern]}, function(): void): void} when
+ * the onKeyPress and onKeyRelease callbacks registered inside the function only run when the active window matches
  * @property {function([string]): void} sendKeys
- * Note that this function needs to be called at the outermost level, not in callback functions or if statements
- * Otherwise, the script will not work
- * @property {function([string], function(): void): void} onKeyPress
+ * @property {function([string], function(KeyEvent): void, {repeat: boolean, ordered: boolean, devices: [Pattern]}=): void} onKeyPress
+ * @property {function([string], function(KeyEvent): void, {ordered: boolean, devices: [Pattern]}=): void} onKeyRelease
+ * @property {function(): {name: string, path: string}|null} getDevice
+ * @property {function(string, {tap: [string], hold: [string], timeoutMs: number}): void} onTapHold
+ * @property {function([string|[string]], function(KeyEvent): void, {timeoutMs: number}=): void} onSequence
+ * @property {function([string]): void} setLeader
+ * @property {function([string], function(KeyEvent): void, {timeoutMs: number, devices: [Pattern]}=): void} onCombo
+ * @property {function(string, Object<string, string|[string]>, {hold: string, toggle: string, oneShot: string}=): void} layer
+ * @property {function(string): void} activateLayer
+ * @property {function(string): void} deactivateLayer
+ * @property {function(string): void} toggleLayer
+ * @property {function(string): void} oneShotLayer
+ * @property {function(): [string]} getActiveLayers
+ * @property {function([string]): void} setStickyModifiers
+ * @property {function(string, function(KeyEvent): void, {timeoutMs: number}=): void} onModifierTap
+ * @property {function([string]): void} suppressModifierTaps
+ * @property {function([string], "eager"|"lazy"|"never", {windowClass: string|[string]}=): void} setModifierPolicy
+ * @property {function(string, string, {immediate: boolean, windowClass: string|[string]}=): void} hotstring
+ * @property {function(string): void} typeText
+ * @property {function(string): void} typeUnicode
+ * @property {function(string, string=): void} setLayout
+ * @property {function([MacroStep], {keyDelayMs: number}=): void} macro
+ * @property {function("ctrl-shift-u"|"ctrl-shift-u-enter", {windowClass: string|[string]}=): void} setUnicodeMethod
+ * @property {function(string, {persist: boolean}=): void} startRecording
+ * @property {function(): void} stopRecording
+ * @property {function(): boolean} isRecording
+ * @property {function(string, {timing: "original"|"compressed", keyDelayMs: number}=): void} replay
+ * @property {function({delayMs: number, intervalMs: number}|boolean): void} setKeyRepeat
+ * @property {function(string|[string], {detach: boolean, cwd: string, env: Object<string, string>}=): number} exec
+ * @property {function(string|[string], {timeoutMs: number, cwd: string, env: Object<string, string>}=): Promise<{stdout: string, stderr: string, code: number}>} run
+ * @property {function(function(Window, Window|null): void): void} onWindowFocus
+ * @property {DBus} dbus
+ * @property {Store} store
+ */
+
+/**
+ * @typedef {Object} Window the active window
+ * @property {string} title
+ * @property {string} class
+ */
+
+/**
+ * @typedef {string|RegExp} Pattern a string is a glob with * and ?
+ */
+
+/**
+ * @typedef {Object} DBus the session bus
+ * @property {function(string, string, string, string, [*]=, {signature: string, timeoutMs: number}=): Promise<*>} call
+ * @property {function({sender: string, path: string, interface: string, member: string}, function([*], {sender: string, path: string, interface: string, member: string}): void): void} onSignal
+ */
+
+/**
+ * @typedef {Object} Store values are saved as JSON and kept between runs
+ * @property {function(string, *=): *} get
+ * @property {function(string, *): void} set
+ * @property {function(string): void} delete
+ */
+
+/**
+ * @typedef {Object} KeyEvent
+ * @property {"press"|"release"|"repeat"} type
+ * @property {boolean} pressed
+ * @property {boolean} repeat
+ * @property {[string]} keys in press order
+ * @property {number} time timestamp in milliseconds
+ */
+
+/**
+ * @typedef {{press: string|[string]}|{release: string|[string]}|{tap: string|[string]}|{delay: number}|{text: string}|{unicode: string}} MacroStep
  */
 
 
@@ -14,10 +84,9 @@ const Terminals = ["kitty", "Gnome-terminal", "org.gnome.Terminal", "com.mitchel
 const JetBrains = ["jetbrains-goland", "jetbrains-pycharm"]
 const VimModeEnabled = ["Cursor"] + JetBrains
 
-const curWindowClass = KeySwift.getActiveWindowClass();
-const inTerminal = Terminals.includes(curWindowClass);
-const inVimMode = VimModeEnabled.includes(curWindowClass)
-const inJetBrains = JetBrains.includes(curWindowClass)
+const inTerminal = () => Terminals.includes(KeySwift.getActiveWindowClass());
+const inVimMode = () => VimModeEnabled.includes(KeySwift.getActiveWindowClass())
+const inJetBrains = () => /^jetbrains-/.test(KeySwift.getActiveWindow()?.class ?? "")
 
 const chromeChangeTabShortcuts = {
     "cmd,1": ["ctrl", "1"],
@@ -70,19 +139,21 @@ const sublimeTextShortcuts = {
 }
 
 KeySwift.onKeyPress(["cmd", "c"], () => {
+	const curWindowClass = KeySwift.getActiveWindowClass();
 	if (curWindowClass === "kitty") {
 		return
 	}
-    if (inTerminal) {
+    if (inTerminal()) {
         KeySwift.sendKeys(["ctrl", "shift", "c"]);
     } else {
-        if (!inJetBrains) {
+        if (!inJetBrains()) {
             KeySwift.sendKeys(["ctrl", "c"]);
         }
     }
 });
 
 KeySwift.onKeyPress(["cmd", "v"], () => {
+	const curWindowClass = KeySwift.getActiveWindowClass();
 	if (curWindowClass === "kitty") {
 		return
 	}
@@ -91,57 +162,51 @@ KeySwift.onKeyPress(["cmd", "v"], () => {
         return
     }
 
-    if (inTerminal) {
+    if (inTerminal()) {
         KeySwift.sendKeys(["cmd", "shift", "v"]);
     } else {
-        if (!inJetBrains) {
+        if (!inJetBrains()) {
             KeySwift.sendKeys(["ctrl", "v"]);
         }
     }
 });
 
 KeySwift.onKeyPress(["cmd", "w"], () => {
-    if (curWindowClass === "Cursor") {
+    if (KeySwift.getActiveWindowClass() === "Cursor") {
         KeySwift.sendKeys(["ctrl", "4"]);
     }
 });
 
 for (const [key, value] of Object.entries(macOSLikeShortcuts)) {
     KeySwift.onKeyPress(key.split(","), () => {
-        if (!inTerminal && !inJetBrains) {
+        if (!inTerminal() && !inJetBrains()) {
             KeySwift.sendKeys(value);
         }
     });
 }
 
-for (const [key, value] of Object.entries(chromeChangeTabShortcuts)) {
-    KeySwift.onKeyPress(key.split(","), () => {
-        if (curWindowClass === "Google-chrome") {
-            KeySwift.sendKeys(value);
-        }
-    });
-}
+KeySwift.when({class: "Google-chrome"}, () => {
+    for (const [key, value] of Object.entries(chromeChangeTabShortcuts)) {
+        KeySwift.onKeyPress(key.split(","), () => KeySwift.sendKeys(value));
+    }
+});
 
 for (const [key, value] of Object.entries(emacsShortcuts)) {
     KeySwift.onKeyPress(key.split(","), () => {
-        if (!inTerminal && !inVimMode) {
+        if (!inTerminal() && !inVimMode()) {
             KeySwift.sendKeys(value);
         }
     });
 }
 
-for (const [key, value] of Object.entries(jetBrainsShortcuts)) {
-    KeySwift.onKeyPress(key.split(","), () => {
-        if (inJetBrains) {
-            KeySwift.sendKeys(value);
-        }
-    });
-}
+KeySwift.when({class: /^jetbrains-/}, () => {
+    for (const [key, value] of Object.entries(jetBrainsShortcuts)) {
+        KeySwift.onKeyPress(key.split(","), () => KeySwift.sendKeys(value));
+    }
+});
 
-for (const [key, value] of Object.entries(sublimeTextShortcuts)) {
-    KeySwift.onKeyPress(key.split(","), () => {
-        if (curWindowClass === "sublime_text") {
-            KeySwift.sendKeys(value);
-        }
-    });
-}
+KeySwift.when({class: "sublime_text"}, () => {
+    for (const [key, value] of Object.entries(sublimeTextShortcuts)) {
+        KeySwift.onKeyPress(key.split(","), () => KeySwift.sendKeys(value));
+    }
+});
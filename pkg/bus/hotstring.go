@@ -0,0 +1,96 @@
+package bus
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const (
+	maxTyped = 64
+	// terminators end the hotstrings which are not immediate
+	terminators = " \n\t.,;:!?-()[]{}'\"/\\"
+)
+
+// typedBuffer keeps the recently typed characters
+type typedBuffer struct {
+	mu    sync.Mutex
+	typed []rune
+}
+
+// ResetTyped clears the typed characters, i.e. after a shortcut, a navigation key or focus change
+func (m *Impl) ResetTyped() {
+	m.typed.mu.Lock()
+	defer m.typed.mu.Unlock()
+	m.typed.typed = m.typed.typed[:0]
+}
+
+// Typed records a key typed through to the focused window on the device and expands the hotstring it completes.
+// The key is released before the expansion, it returns true in that case.
+func (m *Impl) Typed(device *engine.Device, key keys.Key, shift bool) bool {
+	b := &m.typed
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if key == golibevdev.KeyBackspace {
+		if len(b.typed) > 0 {
+			b.typed = b.typed[:len(b.typed)-1]
+		}
+		return false
+	}
+
+	c, ok := m.char(key, shift)
+	if !ok {
+		b.typed = b.typed[:0]
+		return false
+	}
+	b.typed = append(b.typed, c)
+	if len(b.typed) > maxTyped {
+		b.typed = b.typed[len(b.typed)-maxTyped:]
+	}
+
+	typed := string(b.typed)
+	class := m.GetActiveWindowClass()
+	for _, hs := range m.engine().Hotstrings() {
+		if !hs.Match(class) {
+			continue
+		}
+
+		if hs.Immediate && strings.HasSuffix(typed, hs.Trigger) {
+			m.expand(device, hs, key, "", shift)
+			b.typed = b.typed[:0]
+			return true
+		}
+
+		if !hs.Immediate && strings.ContainsRune(terminators, c) && strings.HasSuffix(typed[:len(typed)-len(string(c))], hs.Trigger) {
+			m.expand(device, hs, key, string(c), shift)
+			b.typed = b.typed[:0]
+			return true
+		}
+	}
+	return false
+}
+
+// expand erases the trigger and the terminator typed, then types the replacement followed by the terminator
+func (m *Impl) expand(device *engine.Device, hs engine.Hotstring, last keys.Key, terminator string, shift bool) {
+	slog.Debug("expand hotstring", "trigger", hs.Trigger)
+	m.beforeSendKeys(device)()
+
+	// the last key typed and the shift typing it are still held
+	_ = m.out.WriteEvent(golibevdev.EvKey, last, 0)
+	if shift {
+		_ = m.out.WriteEvent(golibevdev.EvKey, golibevdev.KeyLeftShift, 0)
+		_ = m.out.WriteEvent(golibevdev.EvKey, golibevdev.KeyRightShift, 0)
+	}
+	_ = m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
+
+	for range []rune(hs.Trigger + terminator) {
+		m.SendKeys([]keys.Key{golibevdev.KeyBackspace})
+	}
+	m.SendText(hs.Replacement + terminator)
+}
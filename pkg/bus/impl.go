@@ -4,26 +4,55 @@ import (
 	"fmt"
 	"log/slog"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	godbus "github.com/godbus/dbus/v5"
 	"github.com/jialeicui/golibevdev"
 
+	"github.com/jialeicui/keyswift/pkg/dbusclient"
 	"github.com/jialeicui/keyswift/pkg/engine"
 	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/layout"
 	"github.com/jialeicui/keyswift/pkg/wininfo"
 )
 
+// EventWriter writes events to the virtual keyboard
+type EventWriter interface {
+	WriteEvent(evType golibevdev.EventType, code golibevdev.EventCode, value int32) error
+}
+
 // Impl processes events
 type Impl struct {
-	curFocusWindow *wininfo.WinInfo
-	engine         engine.Engine
+	// curFocusWindow is written by the window monitor and read by the device goroutines
+	curFocusWindow atomic.Pointer[wininfo.WinInfo]
 	windowInfo     wininfo.WinGetter
-	out            *golibevdev.UInputDev
+	// eng runs the script, it's replaced when the config is reloaded
+	eng        engine.Engine
+	engMu      sync.RWMutex
+	engineOpts []engine.Option
+	// dbus is shared by the engines, it's set once the window monitor is connected, it's guarded by engMu
+	dbus *dbusclient.Client
+	out  EventWriter
+	// rec records the events of the input devices while recording
+	rec *recorder
+	// outMu keeps the frames of the macros and SendKeys apart
+	outMu sync.Mutex
+	// macroMu runs the macros one after another
+	macroMu sync.Mutex
+	layers  layerState
+	typed   typedBuffer
+	// layout is the keyboard layout to type text, nil means the US layout
+	layout atomic.Pointer[layout.Layout]
 
-	beforeSendKeysPerSession func()
+	// beforeSend are the hooks of the devices run before the first keys sent by a session of their events
+	beforeSendMu sync.Mutex
+	beforeSend   map[*engine.Device]func()
 }
 
-// New creates a new bus implementation
-func New(script string, windowInfo wininfo.WinGetter, out *golibevdev.UInputDev) (*Impl, error) {
+// New creates a new bus implementation, opts configure the engine running the script
+func New(script string, windowInfo wininfo.WinGetter, out EventWriter, opts ...engine.Option) (*Impl, error) {
 	if script == "" {
 		return nil, fmt.Errorf("script is required")
 	}
@@ -31,14 +60,19 @@ func New(script string, windowInfo wininfo.WinGetter, out *golibevdev.UInputDev)
 	manager := &Impl{
 		windowInfo: windowInfo,
 		out:        out,
+		rec:        newRecorder(),
+		engineOpts: opts,
 	}
 
-	e, err := engine.NewQuickJS(script)
+	e, err := engine.NewQuickJS(script, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create engine: %w", err)
 	}
 
-	manager.engine = e
+	manager.eng = e
+	e.SetBusFactory(manager.newTimerSession)
+	manager.setDBus(windowInfo)
+	manager.loadLayout()
 
 	// Listen for window focus changes
 	if windowInfo != nil {
@@ -51,69 +85,260 @@ func New(script string, windowInfo wininfo.WinGetter, out *golibevdev.UInputDev)
 	return manager, nil
 }
 
-func (m *Impl) SetBeforeSendKeysPerSession(fn func()) {
-	m.beforeSendKeysPerSession = fn
+// engine returns the engine running the current script
+func (m *Impl) engine() engine.Engine {
+	m.engMu.RLock()
+	defer m.engMu.RUnlock()
+	return m.eng
+}
+
+// Reload replaces the engine with one running the new script, the current one is kept if the script fails.
+// The state of the old script is dropped: the active layers, the typed text and the pending callbacks.
+func (m *Impl) Reload(script string) error {
+	e, err := engine.NewQuickJS(script, m.engineOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create engine: %w", err)
+	}
+	e.SetBusFactory(m.newTimerSession)
+
+	m.engMu.Lock()
+	old := m.eng
+	m.eng = e
+	if m.dbus != nil {
+		// the subscriptions of the old engine go with it
+		m.dbus.Reset()
+		e.SetDBus(m.dbus)
+	}
+	m.engMu.Unlock()
+	old.Release()
+
+	m.resetLayers()
+	m.ResetTyped()
+	m.loadLayout()
+	return nil
+}
+
+// Close releases the engine, the changes of the store not written yet are saved
+func (m *Impl) Close() {
+	m.engine().Release()
+}
+
+// SetBeforeSendKeys sets the hook of the device run before the first keys sent by a session of its events,
+// e.g. to release the modifiers it passed through
+func (m *Impl) SetBeforeSendKeys(device *engine.Device, fn func()) {
+	m.beforeSendMu.Lock()
+	defer m.beforeSendMu.Unlock()
+	if m.beforeSend == nil {
+		m.beforeSend = map[*engine.Device]func(){}
+	}
+	m.beforeSend[device] = fn
+}
+
+// beforeSendKeys returns the hook of the device, the sessions without a device have none
+func (m *Impl) beforeSendKeys(device *engine.Device) func() {
+	m.beforeSendMu.Lock()
+	defer m.beforeSendMu.Unlock()
+	if fn, ok := m.beforeSend[device]; ok && device != nil {
+		return fn
+	}
+	return func() {}
 }
 
 // ProcessEvent processes an event through the current bus
-func (m *Impl) ProcessEvent(event *Event) (bool, error) {
-	if event == nil || event.KeyPress == nil {
-		return false, nil
+func (m *Impl) ProcessEvent(event *Event) (Result, error) {
+	if event == nil {
+		return Result{}, nil
+	}
+	if event.WindowFocus != nil {
+		return m.processWindowFocus(event.WindowFocus)
+	}
+	if event.KeyPress == nil {
+		return Result{}, nil
 	}
 
-	s := newSession(m, event.KeyPress.Keys, m.beforeSendKeysPerSession)
-	err := m.engine.Run(s)
+	s := newSession(m, event.KeyPress, m.beforeSendKeys(event.KeyPress.Device))
+	err := m.engine().Run(s)
 	if err != nil {
-		return false, fmt.Errorf("failed to run engine: %w", err)
+		return s.Result(), fmt.Errorf("failed to run engine: %w", err)
 	}
-	return s.Handled(), nil
+	return s.Result(), nil
+}
+
+// processWindowFocus invokes the callbacks of the window focus change
+func (m *Impl) processWindowFocus(event *WindowFocusEvent) (Result, error) {
+	// the focus change isn't a key event, the keys passed through are left alone
+	s := newSession(m, &KeyPressEvent{Pressed: true, Time: time.Now()}, func() {})
+	err := m.engine().RunWindowFocus(s, toWindow(event.Window), toWindow(event.Previous))
+	if err != nil {
+		return s.Result(), fmt.Errorf("failed to run window focus: %w", err)
+	}
+	return s.Result(), nil
+}
+
+func toWindow(winInfo *wininfo.WinInfo) *engine.Window {
+	if winInfo == nil {
+		return nil
+	}
+	return &engine.Window{Title: winInfo.Title, Class: winInfo.Class}
+}
+
+// newTimerSession creates the session of a timer callback, there is no key event behind it
+// and the keys passed through by the devices are left alone
+func (m *Impl) newTimerSession() engine.Bus {
+	return newSession(m, &KeyPressEvent{Pressed: true, Time: time.Now()}, func() {})
+}
+
+// ExpireSequence ends the pending key sequence of the device after its timeout
+func (m *Impl) ExpireSequence(device *engine.Device) (Result, error) {
+	s := newSession(m, &KeyPressEvent{Pressed: true, Time: time.Now(), Device: device}, m.beforeSendKeys(device))
+	err := m.engine().ExpireSequence(s)
+	if err != nil {
+		return s.Result(), fmt.Errorf("failed to expire sequence: %w", err)
+	}
+	return s.Result(), nil
+}
+
+// TapHold returns the tap-hold binding of the key
+func (m *Impl) TapHold(key keys.Key) (engine.TapHold, bool) {
+	return m.engine().TapHold(key)
+}
+
+// IsStickyModifier returns true if tapping the modifier arms it for the next key
+func (m *Impl) IsStickyModifier(key keys.Key) bool {
+	return m.engine().IsStickyModifier(key)
+}
+
+// ModifierTap returns the binding of the modifier tapped alone
+func (m *Impl) ModifierTap(key keys.Key) (engine.ModifierTap, bool) {
+	return m.engine().ModifierTap(key)
+}
+
+// RunModifierTap invokes the callback of a modifier tapped alone on the device at t
+func (m *Impl) RunModifierTap(tap engine.ModifierTap, device *engine.Device, t time.Time) (Result, error) {
+	s := newSession(m, &KeyPressEvent{Keys: []keys.Key{tap.Key}, Pressed: true, Time: t, Device: device}, m.beforeSendKeys(device))
+	err := m.engine().RunModifierTap(s, tap.ID)
+	if err != nil {
+		return s.Result(), fmt.Errorf("failed to run modifier tap: %w", err)
+	}
+	return s.Result(), nil
+}
+
+// IsTapSuppressed returns true if the system must not see the modifier tapped alone
+func (m *Impl) IsTapSuppressed(key keys.Key) bool {
+	return m.engine().IsTapSuppressed(key)
+}
+
+// ModifierPolicy returns when the modifier pressed in the active window is forwarded
+func (m *Impl) ModifierPolicy(key keys.Key) engine.ModifierPolicy {
+	return m.engine().ModifierPolicy(key, m.GetActiveWindowClass())
+}
+
+// KeyRepeat returns how the keys sent for a held chord are repeated
+func (m *Impl) KeyRepeat() engine.KeyRepeat {
+	return m.engine().KeyRepeat()
+}
+
+// Combos returns the combos registered by the script
+func (m *Impl) Combos() []engine.Combo {
+	return m.engine().Combos()
+}
+
+// RunCombo invokes the callback of a combo pressed on the device at t
+func (m *Impl) RunCombo(combo engine.Combo, device *engine.Device, t time.Time) (Result, error) {
+	s := newSession(m, &KeyPressEvent{Keys: combo.Keys, Pressed: true, Time: t, Device: device}, m.beforeSendKeys(device))
+	err := m.engine().RunCombo(s, combo.ID)
+	if err != nil {
+		return s.Result(), fmt.Errorf("failed to run combo: %w", err)
+	}
+	return s.Result(), nil
 }
 
 // handleWindowFocus handles window focus change events
 func (m *Impl) handleWindowFocus(winInfo *wininfo.WinInfo) {
-	m.curFocusWindow = winInfo
+	prev := m.curFocusWindow.Swap(winInfo)
+	// the hotstrings don't span windows
+	m.ResetTyped()
+
+	if winInfo == nil || (prev != nil && *prev == *winInfo) {
+		return
+	}
+	_, err := m.ProcessEvent(&Event{WindowFocus: &WindowFocusEvent{Window: winInfo, Previous: prev}})
+	if err != nil {
+		slog.Error("failed to process window focus", "error", err)
+	}
 }
 
 func (m *Impl) GetActiveWindowClass() string {
-	slog.Debug("GetActiveWindowClass", "curFocusWindow", m.curFocusWindow)
-	if m.curFocusWindow != nil {
-		return m.curFocusWindow.Class
+	win := m.curFocusWindow.Load()
+	slog.Debug("GetActiveWindowClass", "curFocusWindow", win)
+	if win != nil {
+		return win.Class
 	}
 	return ""
 }
 
 func (m *Impl) SendKeys(keyCodes []keys.Key) {
 	slog.Debug("SendKeys", "keyCodes", keyCodes)
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+
+	chord := modifiersFirst(keyCodes)
+	m.writeChord(chord, 1)
+	m.writeChord(chord, 0)
+
+	slog.Debug("SendKeys done", "input", keyCodes)
+}
+
+// PressKeys presses the chord on the virtual keyboard and holds it until ReleaseKeys
+func (m *Impl) PressKeys(keyCodes []keys.Key) {
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+	m.writeChord(modifiersFirst(keyCodes), 1)
+}
+
+// RepeatKeys sends the repeat events of the chord held by PressKeys, the modifiers aren't repeated
+func (m *Impl) RepeatKeys(keyCodes []keys.Key) {
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+	var repeated []keys.Key
+	for _, key := range keyCodes {
+		if !keys.IsModifier(key) {
+			repeated = append(repeated, key)
+		}
+	}
+	m.writeChord(repeated, 2)
+}
 
+// ReleaseKeys releases the chord held by PressKeys
+func (m *Impl) ReleaseKeys(keyCodes []keys.Key) {
+	m.outMu.Lock()
+	defer m.outMu.Unlock()
+	m.writeChord(modifiersFirst(keyCodes), 0)
+}
+
+// modifiersFirst returns a copy of the chord with the modifier keys first
+func modifiersFirst(keyCodes []keys.Key) []keys.Key {
 	cloned := append([]keys.Key{}, keyCodes...)
-	sort.Slice(cloned, func(i, j int) bool {
-		return keys.IsModifier(cloned[i])
+	sort.SliceStable(cloned, func(i, j int) bool {
+		return keys.IsModifier(cloned[i]) && !keys.IsModifier(cloned[j])
 	})
+	return cloned
+}
 
-	// modifier keys first
-	for _, key := range cloned {
-		err := m.out.WriteEvent(golibevdev.EvKey, key, 1)
+// writeChord writes the events of the keys followed by a sync event, the caller holds outMu
+func (m *Impl) writeChord(chord []keys.Key, value int32) {
+	for _, key := range chord {
+		err := m.out.WriteEvent(golibevdev.EvKey, key, value)
 		if err != nil {
 			slog.Error("failed to send key event", "error", err)
 		}
 	}
-
-	// send sync event
-	_ = m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
-
-	// send release event
-	for _, key := range cloned {
-		_ = m.out.WriteEvent(golibevdev.EvKey, key, 0)
-	}
-
-	// send sync event
 	_ = m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
-
-	slog.Debug("SendKeys done", "input", keyCodes)
 }
 
 func (m *Impl) UpdateWindowMonitor(windowInfo wininfo.WinGetter) {
 	m.windowInfo = windowInfo
+	m.setDBus(windowInfo)
 	if windowInfo != nil {
 		err := windowInfo.OnActiveWindowChange(m.handleWindowFocus)
 		if err != nil {
@@ -121,3 +346,13 @@ func (m *Impl) UpdateWindowMonitor(windowInfo wininfo.WinGetter) {
 		}
 	}
 }
+
+// setDBus shares the session bus connection of the window monitor with the scripts
+func (m *Impl) setDBus(windowInfo wininfo.WinGetter) {
+	if c, ok := windowInfo.(interface{ Conn() *godbus.Conn }); ok && c.Conn() != nil {
+		m.engMu.Lock()
+		defer m.engMu.Unlock()
+		m.dbus = dbusclient.New(c.Conn())
+		m.eng.SetDBus(m.dbus)
+	}
+}
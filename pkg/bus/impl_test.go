@@ -0,0 +1,65 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+func TestReload(t *testing.T) {
+	must := require.New(t)
+
+	e, err := engine.NewQuickJS(`KeySwift.setKeyRepeat(false);`)
+	must.NoError(err)
+	m := &Impl{eng: e}
+	defer func() { m.engine().Release() }()
+	m.ActivateLayer("nav", engine.LayerToggle)
+
+	// a broken script keeps the running one
+	must.Error(m.Reload(`KeySwift.onKeyPress(`))
+	must.Same(e, m.engine())
+	must.False(m.KeyRepeat().Enabled)
+	must.Equal([]string{"nav"}, m.ActiveLayers())
+
+	must.NoError(m.Reload(`KeySwift.layer("nav", {h: "left"}, {toggle: "f1"});`))
+	must.NotSame(e, m.engine())
+	must.True(m.KeyRepeat().Enabled)
+	must.Empty(m.ActiveLayers())
+	_, ok := m.engine().Layer("nav")
+	must.True(ok)
+	must.ErrorIs(e.Run(nil), engine.ErrReleased)
+}
+
+// eventLog records the key events written to the virtual keyboard
+type eventLog struct {
+	events []string
+}
+
+func (l *eventLog) WriteEvent(evType golibevdev.EventType, code golibevdev.EventCode, value int32) error {
+	if evType == golibevdev.EvKey {
+		l.events = append(l.events, fmt.Sprintf("%s:%d", keys.Name(code.(keys.Key)), value))
+	}
+	return nil
+}
+
+func TestHeldKeys(t *testing.T) {
+	must := require.New(t)
+
+	out := &eventLog{}
+	m := &Impl{out: out}
+	chord := []keys.Key{golibevdev.KeyLeft, golibevdev.KeyLeftShift}
+	m.SendKeys(chord)
+	m.PressKeys(chord)
+	m.RepeatKeys(chord)
+	m.RepeatKeys(chord)
+	m.ReleaseKeys(chord)
+	must.Equal([]string{
+		"leftshift:1", "left:1", "leftshift:0", "left:0",
+		"leftshift:1", "left:1", "left:2", "left:2", "leftshift:0", "left:0",
+	}, out.events)
+}
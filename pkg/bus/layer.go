@@ -0,0 +1,113 @@
+package bus
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// layerState keeps the active layers shared by all devices
+type layerState struct {
+	mu sync.Mutex
+	// active is in activation order, the last one is on top
+	active []string
+	// oneShot is the layer armed for the next key press
+	oneShot string
+}
+
+// ActivateLayer activates a layer in the mode
+func (m *Impl) ActivateLayer(name string, mode engine.LayerMode) {
+	l := &m.layers
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx := slices.Index(l.active, name)
+	switch mode {
+	case engine.LayerOneShot:
+		l.oneShot = name
+	case engine.LayerToggle:
+		if idx >= 0 {
+			l.active = slices.Delete(l.active, idx, idx+1)
+		} else {
+			l.active = append(l.active, name)
+		}
+	default:
+		if idx < 0 {
+			l.active = append(l.active, name)
+		}
+	}
+	slog.Debug("layers changed", "active", l.active, "oneShot", l.oneShot)
+}
+
+// DeactivateLayer deactivates a layer, it disarms the one-shot layer as well
+func (m *Impl) DeactivateLayer(name string) {
+	l := &m.layers
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active = slices.DeleteFunc(l.active, func(s string) bool {
+		return s == name
+	})
+	if l.oneShot == name {
+		l.oneShot = ""
+	}
+	slog.Debug("layers changed", "active", l.active, "oneShot", l.oneShot)
+}
+
+// resetLayers deactivates all layers
+func (m *Impl) resetLayers() {
+	l := &m.layers
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active = nil
+	l.oneShot = ""
+}
+
+// ActiveLayers returns the active layers, the last one is on top
+func (m *Impl) ActiveLayers() []string {
+	l := &m.layers
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := slices.Clone(l.active)
+	if l.oneShot != "" && !slices.Contains(active, l.oneShot) {
+		active = append(active, l.oneShot)
+	}
+	return active
+}
+
+// LayerKey returns the layer activated by the key
+func (m *Impl) LayerKey(key keys.Key) (engine.LayerKey, bool) {
+	return m.engine().LayerKey(key)
+}
+
+// MapKey returns the keys a key press is sent as according to the active layers.
+// The one-shot layer is consumed by the key press unless it's a modifier.
+func (m *Impl) MapKey(key keys.Key) ([]keys.Key, bool) {
+	l := &m.layers
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := slices.Clone(l.active)
+	if l.oneShot != "" {
+		names = append(names, l.oneShot)
+		if !keys.IsModifier(key) {
+			l.oneShot = ""
+		}
+	}
+
+	e := m.engine()
+	for i := len(names) - 1; i >= 0; i-- {
+		layer, ok := e.Layer(names[i])
+		if !ok {
+			continue
+		}
+		if to, ok := layer.Keys[key]; ok {
+			return to, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// RunMacro runs the steps of a macro in the background, the macros run one after another
+func (m *Impl) RunMacro(steps []engine.MacroStep, keyDelay time.Duration) {
+	go func() {
+		m.macroMu.Lock()
+		defer m.macroMu.Unlock()
+		m.runMacro(steps, keyDelay)
+	}()
+}
+
+// writeKey sends the key event in its own frame, some apps drop the events sharing a frame
+func (m *Impl) writeKey(code keys.Key, value int32) {
+	m.outMu.Lock()
+	err := m.out.WriteEvent(golibevdev.EvKey, code, value)
+	_ = m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
+	m.outMu.Unlock()
+	if err != nil {
+		slog.Error("failed to send key event", "error", err)
+	}
+}
+
+func (m *Impl) runMacro(steps []engine.MacroStep, keyDelay time.Duration) {
+	slog.Debug("run macro", "steps", steps, "keyDelay", keyDelay)
+
+	var pressed []keys.Key
+	writeKey := func(code keys.Key, value int32) {
+		m.writeKey(code, value)
+		time.Sleep(keyDelay)
+	}
+	tap := func(codes []keys.Key) {
+		for _, code := range codes {
+			writeKey(code, 1)
+		}
+		for i := len(codes) - 1; i >= 0; i-- {
+			writeKey(codes[i], 0)
+		}
+	}
+
+	for _, step := range steps {
+		switch step.Action {
+		case engine.MacroPress:
+			for _, code := range step.Keys {
+				writeKey(code, 1)
+				pressed = append(pressed, code)
+			}
+		case engine.MacroRelease:
+			for _, code := range step.Keys {
+				writeKey(code, 0)
+				pressed = slices.DeleteFunc(pressed, func(k keys.Key) bool {
+					return k == code
+				})
+			}
+		case engine.MacroTap:
+			tap(step.Keys)
+		case engine.MacroDelay:
+			time.Sleep(step.Delay)
+		case engine.MacroText:
+			for _, chord := range m.textChords(step.Text) {
+				tap(chord)
+			}
+		case engine.MacroUnicode:
+			method := m.engine().UnicodeMethod(m.GetActiveWindowClass())
+			for _, c := range step.Text {
+				for _, chord := range m.unicodeChords(c, method) {
+					tap(chord)
+				}
+			}
+		}
+	}
+
+	// don't leave keys stuck
+	for i := len(pressed) - 1; i >= 0; i-- {
+		slog.Warn("release the key pressed by macro", "key", keys.Name(pressed[i]))
+		writeKey(pressed[i], 0)
+	}
+}
@@ -1,8 +1,12 @@
 package bus
 
 import (
+	"time"
+
 	"github.com/jialeicui/golibevdev"
 
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
 	"github.com/jialeicui/keyswift/pkg/wininfo"
 )
 
@@ -16,11 +20,29 @@ type Event struct {
 	WindowFocus *WindowFocusEvent
 }
 
+// Result is the outcome of processing an event
+type Result struct {
+	// Handled means the event triggered an action and should not be forwarded
+	Handled bool
+	// Pending means the event is the prefix of a key sequence,
+	// it should be held back until the sequence ends or Timeout passes
+	Pending bool
+	Timeout time.Duration
+	// Aborted means the pending key sequence is broken, the events held back should be replayed
+	Aborted bool
+	// Repeat is the last chord sent by the callbacks, it's repeated while the keys are held
+	Repeat []keys.Key
+}
+
 // KeyPressEvent represents a keyboard key press
 type KeyPressEvent struct {
+	// Keys are the pressed keys, for release it's the keys pressed before the release
 	Keys     []golibevdev.KeyEventCode
 	Pressed  bool // true for press, false for release
 	Repeated bool // true if key repeat
+	Time     time.Time
+	// Device is the input device of the keys, nil if the event doesn't come from a device
+	Device *engine.Device
 }
 
 // MouseClickEvent represents a mouse click
@@ -34,4 +56,6 @@ type MouseClickEvent struct {
 // WindowFocusEvent represents a window focus change
 type WindowFocusEvent struct {
 	Window *wininfo.WinInfo
+	// Previous is the window focused before, nil if there is none
+	Previous *wininfo.WinInfo
 }
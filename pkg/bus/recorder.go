@@ -0,0 +1,255 @@
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/utils"
+)
+
+var registerRe = regexp.MustCompile(`^[\w-]+$`)
+
+// RecordedEvent is a key event read from the input devices while recording
+type RecordedEvent struct {
+	Key   string `json:"key"`
+	Value int32  `json:"value"`
+	// TimeMs is the time since the recording started
+	TimeMs float64 `json:"timeMs"`
+}
+
+// recorder records the key events of the input devices into the register being recorded
+type recorder struct {
+	mu       sync.Mutex
+	register string
+	persist  bool
+	start    time.Time
+	events   []RecordedEvent
+	// held is the keys pressed since the recording started and not released yet
+	held      map[string]struct{}
+	registers map[string][]RecordedEvent
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		registers: map[string][]RecordedEvent{},
+	}
+}
+
+// record records a press or release, the release of a key pressed before the recording started is left out
+func (r *recorder) record(key keys.Key, value int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.register == "" {
+		return
+	}
+
+	name := keys.Name(key)
+	switch value {
+	case 0:
+		if _, ok := r.held[name]; !ok {
+			return
+		}
+		delete(r.held, name)
+	case 1:
+		r.held[name] = struct{}{}
+	default:
+		// the virtual keyboard repeats the keys held by the replay
+		return
+	}
+	r.events = append(r.events, RecordedEvent{
+		Key:    name,
+		Value:  value,
+		TimeMs: float64(time.Since(r.start).Microseconds()) / 1000,
+	})
+}
+
+// trimHeld drops the presses of the keys still held, like the chord stopping the recording
+func trimHeld(events []RecordedEvent, held map[string]struct{}) []RecordedEvent {
+	if len(held) == 0 {
+		return events
+	}
+
+	trimmed := make([]RecordedEvent, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		if _, ok := held[ev.Key]; ok && ev.Value == 1 {
+			delete(held, ev.Key)
+			continue
+		}
+		trimmed = append(trimmed, ev)
+	}
+	slices.Reverse(trimmed)
+	return trimmed
+}
+
+// recordingPath returns the file persisting the register
+func recordingPath(register string) (string, error) {
+	dir, err := utils.DefaultStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "recordings", register+".json"), nil
+}
+
+// RecordInput records a key event read from an input device while recording,
+// it's called before the event is handled so the recording is replayed as typed
+func (m *Impl) RecordInput(key keys.Key, value int32) {
+	m.rec.record(key, value)
+}
+
+// StartRecording records the key events of the input devices into the register,
+// the register is saved to disk when the recording stops if persist is set
+func (m *Impl) StartRecording(register string, persist bool) error {
+	if !registerRe.MatchString(register) {
+		return fmt.Errorf("invalid register name %q", register)
+	}
+
+	r := m.rec
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.register != "" {
+		return fmt.Errorf("already recording register %s", r.register)
+	}
+
+	slog.Info("start recording", "register", register)
+	r.register = register
+	r.persist = persist
+	r.start = time.Now()
+	r.events = nil
+	r.held = map[string]struct{}{}
+	return nil
+}
+
+// StopRecording stops the recording and stores it into its register
+func (m *Impl) StopRecording() error {
+	r := m.rec
+	r.mu.Lock()
+	register, persist, events := r.register, r.persist, trimHeld(r.events, r.held)
+	if register != "" {
+		r.registers[register] = events
+	}
+	r.register = ""
+	r.events = nil
+	r.held = nil
+	r.mu.Unlock()
+
+	if register == "" {
+		return errors.New("not recording")
+	}
+	slog.Info("stop recording", "register", register, "events", len(events))
+	if !persist {
+		return nil
+	}
+
+	path, err := recordingPath(register)
+	if err != nil {
+		return fmt.Errorf("failed to save recording: %w", err)
+	}
+	data, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = utils.WriteFileAtomic(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save recording: %w", err)
+	}
+	return nil
+}
+
+// IsRecording returns true while recording
+func (m *Impl) IsRecording() bool {
+	m.rec.mu.Lock()
+	defer m.rec.mu.Unlock()
+	return m.rec.register != ""
+}
+
+// recording returns the events of the register, loading it from disk if it's not recorded in this run
+func (m *Impl) recording(register string) ([]RecordedEvent, error) {
+	if !registerRe.MatchString(register) {
+		return nil, fmt.Errorf("invalid register name %q", register)
+	}
+
+	r := m.rec
+	r.mu.Lock()
+	events, ok := r.registers[register]
+	r.mu.Unlock()
+	if ok {
+		return events, nil
+	}
+
+	path, err := recordingPath(register)
+	if err != nil {
+		return nil, fmt.Errorf("register %s is empty: %w", register, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("register %s is empty: %w", register, err)
+	}
+	if err = json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("failed to load register %s: %w", register, err)
+	}
+
+	r.mu.Lock()
+	r.registers[register] = events
+	r.mu.Unlock()
+	return events, nil
+}
+
+// Replay sends the recorded events of the register in the background, it runs after the pending macros
+func (m *Impl) Replay(register string, opts engine.ReplayOptions) error {
+	events, err := m.recording(register)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]keys.Key, len(events))
+	for i, ev := range events {
+		c, err := keys.GetKeyCodes([]string{ev.Key})
+		if err != nil {
+			return fmt.Errorf("register %s: %w", register, err)
+		}
+		codes[i] = c[0]
+	}
+
+	go func() {
+		m.macroMu.Lock()
+		defer m.macroMu.Unlock()
+
+		slog.Debug("replay", "register", register, "events", len(events), "options", opts)
+		var pressed []keys.Key
+		for i, ev := range events {
+			if opts.OriginalTiming && i > 0 {
+				time.Sleep(time.Duration((ev.TimeMs - events[i-1].TimeMs) * float64(time.Millisecond)))
+			}
+
+			m.writeKey(codes[i], ev.Value)
+			switch ev.Value {
+			case 0:
+				pressed = slices.DeleteFunc(pressed, func(k keys.Key) bool {
+					return k == codes[i]
+				})
+			case 1:
+				pressed = append(pressed, codes[i])
+			}
+			if !opts.OriginalTiming {
+				time.Sleep(opts.KeyDelay)
+			}
+		}
+
+		// the recording may stop while keys are held, don't leave them stuck
+		for i := len(pressed) - 1; i >= 0; i-- {
+			slog.Warn("release the key pressed by replay", "key", keys.Name(pressed[i]))
+			m.writeKey(pressed[i], 0)
+		}
+	}()
+	return nil
+}
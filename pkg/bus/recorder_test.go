@@ -0,0 +1,40 @@
+package bus
+
+import (
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordInput(t *testing.T) {
+	must := require.New(t)
+	m := &Impl{rec: newRecorder()}
+
+	// ctrl+f9 starts the recording, shift+f9 stops it
+	m.RecordInput(golibevdev.KeyLeftCtrl, 1)
+	m.RecordInput(golibevdev.KeyF9, 1)
+	must.NoError(m.StartRecording("a", false))
+	must.Error(m.StartRecording("b", false))
+	m.RecordInput(golibevdev.KeyF9, 0)
+	m.RecordInput(golibevdev.KeyLeftCtrl, 0)
+	m.RecordInput(golibevdev.KeyA, 1)
+	m.RecordInput(golibevdev.KeyA, 2)
+	m.RecordInput(golibevdev.KeyA, 0)
+	m.RecordInput(golibevdev.KeyLeftShift, 1)
+	m.RecordInput(golibevdev.KeyF9, 1)
+	must.True(m.IsRecording())
+	must.NoError(m.StopRecording())
+	must.False(m.IsRecording())
+	m.RecordInput(golibevdev.KeyB, 1)
+
+	events, err := m.recording("a")
+	must.NoError(err)
+	var got []RecordedEvent
+	for _, ev := range events {
+		got = append(got, RecordedEvent{Key: ev.Key, Value: ev.Value})
+	}
+	must.Equal([]RecordedEvent{{Key: "a", Value: 1}, {Key: "a", Value: 0}}, got)
+
+	must.Error(m.StopRecording())
+}
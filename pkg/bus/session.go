@@ -2,6 +2,7 @@ package bus
 
 import (
 	"sync"
+	"time"
 
 	"github.com/jialeicui/keyswift/pkg/engine"
 	"github.com/jialeicui/keyswift/pkg/keys"
@@ -10,35 +11,126 @@ import (
 var _ engine.Bus = (*session)(nil)
 
 type session struct {
-	impl        *Impl
-	handled     bool
-	pressedKeys []keys.Key
-	once        sync.Once
-	beforeSend  func()
+	impl       *Impl
+	result     Result
+	event      *KeyPressEvent
+	once       sync.Once
+	beforeSend func()
 }
 
 func (s *session) GetPressedKeys() []keys.Key {
-	return s.pressedKeys
+	return s.event.Keys
+}
+
+func (s *session) GetKeyEventType() engine.KeyEventType {
+	switch {
+	case !s.event.Pressed:
+		return engine.KeyEventRelease
+	case s.event.Repeated:
+		return engine.KeyEventRepeat
+	default:
+		return engine.KeyEventPress
+	}
+}
+
+func (s *session) GetEventTime() time.Time {
+	return s.event.Time
 }
 
 func (s *session) GetActiveWindowClass() string {
 	return s.impl.GetActiveWindowClass()
 }
 
+func (s *session) GetActiveWindow() *engine.Window {
+	return toWindow(s.impl.curFocusWindow.Load())
+}
+
+func (s *session) GetDevice() *engine.Device {
+	return s.event.Device
+}
+
 func (s *session) SendKeys(codes []keys.Key) {
 	s.once.Do(s.beforeSend)
-	s.handled = true
+	s.result.Handled = true
+	s.result.Repeat = codes
 	s.impl.SendKeys(codes)
 }
 
-func (s *session) Handled() bool {
-	return s.handled
+func (s *session) TypeText(text string) {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	s.impl.SendText(text)
+}
+
+func (s *session) TypeUnicode(text string) {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	s.impl.TypeUnicode(text)
+}
+
+func (s *session) RunMacro(steps []engine.MacroStep, keyDelay time.Duration) {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	s.impl.RunMacro(steps, keyDelay)
+}
+
+// StartRecording swallows the key triggering it, so it's not recorded
+func (s *session) StartRecording(register string, persist bool) error {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	return s.impl.StartRecording(register, persist)
+}
+
+func (s *session) StopRecording() error {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	return s.impl.StopRecording()
+}
+
+func (s *session) IsRecording() bool {
+	return s.impl.IsRecording()
+}
+
+func (s *session) Replay(register string, opts engine.ReplayOptions) error {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+	return s.impl.Replay(register, opts)
+}
+
+func (s *session) PendSequence(timeout time.Duration) {
+	s.result.Pending = true
+	s.result.Timeout = timeout
+}
+
+func (s *session) CompleteSequence() {
+	s.once.Do(s.beforeSend)
+	s.result.Handled = true
+}
+
+func (s *session) AbortSequence() {
+	s.result.Aborted = true
+}
+
+func (s *session) GetActiveLayers() []string {
+	return s.impl.ActiveLayers()
+}
+
+func (s *session) ActivateLayer(name string, mode engine.LayerMode) {
+	s.impl.ActivateLayer(name, mode)
+}
+
+func (s *session) DeactivateLayer(name string) {
+	s.impl.DeactivateLayer(name)
+}
+
+func (s *session) Result() Result {
+	return s.result
 }
 
-func newSession(m *Impl, pressedKeys []keys.Key, beforeSend func()) *session {
+func newSession(m *Impl, event *KeyPressEvent, beforeSend func()) *session {
 	return &session{
-		impl:        m,
-		pressedKeys: pressedKeys,
-		beforeSend:  beforeSend,
+		impl:       m,
+		event:      event,
+		beforeSend: beforeSend,
 	}
 }
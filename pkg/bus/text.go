@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"log/slog"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/layout"
+)
+
+// loadLayout loads the keyboard layout set by the script or configured for the system,
+// the US layout is used if it fails
+func (m *Impl) loadLayout() {
+	name, variant := m.engine().KeyboardLayout()
+	if name == "" {
+		name, variant = layout.Detect()
+	}
+
+	l, err := layout.Load(layout.DefaultDir, name, variant)
+	if err != nil {
+		slog.Warn("failed to load keyboard layout, fallback to us", "layout", name, "variant", variant, "error", err)
+		return
+	}
+	slog.Debug("keyboard layout loaded", "layout", name, "variant", variant)
+	m.layout.Store(l)
+}
+
+// charKeys returns the keys typing the character on the keyboard layout
+func (m *Impl) charKeys(c rune) ([]keys.Key, bool) {
+	l := m.layout.Load()
+	if l == nil {
+		return keys.CharKeys(c)
+	}
+	s, ok := l.Stroke(c)
+	if !ok {
+		return nil, false
+	}
+	return s.Keys(), true
+}
+
+// char returns the character typed by the key on the keyboard layout
+func (m *Impl) char(key keys.Key, shift bool) (rune, bool) {
+	l := m.layout.Load()
+	if l == nil {
+		return keys.Char(key, shift)
+	}
+	return l.Char(layout.Stroke{Key: key, Shift: shift})
+}
+
+// SendText types the text on the keyboard layout,
+// the characters not on the layout are typed by their code points with the unicode method of the active window
+func (m *Impl) SendText(text string) {
+	for _, chord := range m.textChords(text) {
+		m.SendKeys(chord)
+	}
+}
+
+// textChords returns the chords typing the text
+func (m *Impl) textChords(text string) [][]keys.Key {
+	method := m.engine().UnicodeMethod(m.GetActiveWindowClass())
+	var chords [][]keys.Key
+	for _, c := range text {
+		if codes, ok := m.charKeys(c); ok {
+			chords = append(chords, codes)
+			continue
+		}
+		chords = append(chords, m.unicodeChords(c, method)...)
+	}
+	return chords
+}
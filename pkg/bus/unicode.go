@@ -0,0 +1,53 @@
+package bus
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// TypeUnicode types every character of the text by its code point with the unicode method of the active window
+func (m *Impl) TypeUnicode(text string) {
+	method := m.engine().UnicodeMethod(m.GetActiveWindowClass())
+	for _, c := range text {
+		m.SendUnicode(c, method)
+	}
+}
+
+// SendUnicode types a character by its code point
+func (m *Impl) SendUnicode(c rune, method engine.UnicodeMethod) {
+	slog.Debug("SendUnicode", "char", string(c), "method", method)
+	for _, chord := range m.unicodeChords(c, method) {
+		m.SendKeys(chord)
+	}
+}
+
+// unicodeChords returns the chords typing a character by its code point
+func (m *Impl) unicodeChords(c rune, method engine.UnicodeMethod) [][]keys.Key {
+	u := m.unicodeKey('u')
+	chords := [][]keys.Key{{golibevdev.KeyLeftCtrl, golibevdev.KeyLeftShift, u[len(u)-1]}}
+	for _, digit := range fmt.Sprintf("%x", c) {
+		chords = append(chords, m.unicodeKey(digit))
+	}
+
+	switch method {
+	case engine.UnicodeCtrlShiftUEnter:
+		return append(chords, []keys.Key{golibevdev.KeyEnter})
+	default:
+		return append(chords, []keys.Key{golibevdev.KeySpace})
+	}
+}
+
+// unicodeKey returns the keys typing a letter or a hex digit of the unicode input,
+// the US keys are used if the layout has no stroke for it, e.g. the latin letters on the Cyrillic layouts
+func (m *Impl) unicodeKey(c rune) []keys.Key {
+	if codes, ok := m.charKeys(c); ok {
+		return codes
+	}
+	codes, _ := keys.CharKeys(c)
+	return codes
+}
@@ -0,0 +1,40 @@
+package bus
+
+import (
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/layout"
+)
+
+func TestUnicodeChordsCyrillic(t *testing.T) {
+	must := require.New(t)
+
+	l, err := layout.Load("../layout/testdata/xkb", "ru", "")
+	must.NoError(err)
+	_, ok := l.Stroke('u')
+	must.False(ok, "no latin u on the ru layout")
+
+	m := &Impl{}
+	m.layout.Store(l)
+	// ы is U+044B, the latin letters fall back to the US keys, the digits are on the layout
+	must.Equal([][]keys.Key{
+		{golibevdev.KeyLeftCtrl, golibevdev.KeyLeftShift, golibevdev.KeyU},
+		{golibevdev.Key4},
+		{golibevdev.Key4},
+		{golibevdev.KeyB},
+		{golibevdev.KeySpace},
+	}, m.unicodeChords('ы', engine.UnicodeCtrlShiftU))
+
+	must.Equal([][]keys.Key{
+		{golibevdev.KeyLeftCtrl, golibevdev.KeyLeftShift, golibevdev.KeyU},
+		{golibevdev.Key1},
+		{golibevdev.KeyF},
+		{golibevdev.Key4},
+		{golibevdev.KeyEnter},
+	}, m.unicodeChords(0x1f4, engine.UnicodeCtrlShiftUEnter))
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jialeicui/keyswift/pkg/wininfo/dbus"
+)
+
+// MaxOutput is the maximum size of the stdout and stderr kept by Run, the rest is dropped
+const MaxOutput = 64 * 1024
+
+// waitDelay is how long Run waits for the output pipes to close after the command is killed,
+// a child inheriting them may keep them open
+const waitDelay = time.Second
+
+// Options are the options of a command
+type Options struct {
+	// Dir is the working directory, empty means the home directory
+	Dir string
+	// Env are the environment variables added to the session environment
+	Env map[string]string
+}
+
+// Result is the outcome of a command run to completion
+type Result struct {
+	Stdout string
+	Stderr string
+	// Code is the exit code, -1 if the command is killed by a signal
+	Code int
+}
+
+// Env returns the environment of the commands. KeySwift may be started outside of the desktop session,
+// so the variables to reach the session are filled in when they're missing.
+func Env() []string {
+	env := os.Environ()
+	setDefault := func(key, value string) {
+		if os.Getenv(key) == "" && value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+		if _, err := os.Stat(runtimeDir); err != nil {
+			runtimeDir = ""
+		}
+		setDefault("XDG_RUNTIME_DIR", runtimeDir)
+	}
+	if addr, err := dbus.GetDBusAddress(); err == nil {
+		setDefault("DBUS_SESSION_BUS_ADDRESS", addr)
+	}
+	if runtimeDir != "" {
+		if _, err := os.Stat(filepath.Join(runtimeDir, "wayland-0")); err == nil {
+			setDefault("WAYLAND_DISPLAY", "wayland-0")
+		}
+	}
+	if _, err := os.Stat("/tmp/.X11-unix/X0"); err == nil {
+		setDefault("DISPLAY", ":0")
+	}
+	return env
+}
+
+func newCmd(ctx context.Context, argv []string, opts Options) (*exec.Cmd, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("command is empty")
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = Env()
+	for k, v := range opts.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Dir = opts.Dir
+	if cmd.Dir == "" {
+		cmd.Dir, _ = os.UserHomeDir()
+	}
+	return cmd, nil
+}
+
+// Start starts the command in the background and returns its pid, the process is reaped when it exits.
+// A detached command runs in its own session without the stdio of KeySwift, so it outlives KeySwift.
+func Start(argv []string, opts Options, detach bool) (int, error) {
+	cmd, err := newCmd(context.Background(), argv, opts)
+	if err != nil {
+		return 0, err
+	}
+	if detach {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	} else {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	if err = cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start %s: %w", argv[0], err)
+	}
+
+	pid := cmd.Process.Pid
+	slog.Debug("command started", "argv", argv, "pid", pid, "detach", detach)
+	go func() {
+		err := cmd.Wait()
+		slog.Debug("command exited", "argv", argv, "pid", pid, "error", err)
+	}()
+	return pid, nil
+}
+
+// Run runs the command to completion, it's killed when ctx is done
+func Run(ctx context.Context, argv []string, opts Options) (Result, error) {
+	cmd, err := newCmd(ctx, argv, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	stdout := &limitedBuffer{limit: MaxOutput}
+	stderr := &limitedBuffer{limit: MaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// the children are killed with the command in its own process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return Result{}, fmt.Errorf("%s: %w", argv[0], ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return Result{}, fmt.Errorf("failed to run %s: %w", argv[0], err)
+	}
+
+	return Result{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Code:   cmd.ProcessState.ExitCode(),
+	}, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	// buf is not embedded, io.Copy would bypass the limit through bytes.Buffer.ReadFrom
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
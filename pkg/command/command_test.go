@@ -0,0 +1,57 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	must := require.New(t)
+	ctx := context.Background()
+
+	res, err := Run(ctx, []string{"sh", "-c", `echo "$GREETING"; echo oops >&2; exit 3`}, Options{
+		Env: map[string]string{"GREETING": "hello"},
+	})
+	must.NoError(err)
+	must.Equal(Result{Stdout: "hello\n", Stderr: "oops\n", Code: 3}, res)
+
+	res, err = Run(ctx, []string{"pwd"}, Options{Dir: "/"})
+	must.NoError(err)
+	must.Equal("/\n", res.Stdout)
+
+	res, err = Run(ctx, []string{"head", "-c", "100000", "/dev/zero"}, Options{})
+	must.NoError(err)
+	must.Len(res.Stdout, MaxOutput)
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = Run(ctx, []string{"sleep", "10"}, Options{})
+	must.ErrorIs(err, context.DeadlineExceeded)
+
+	// a child holding the output open doesn't outlive the timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = Run(ctx, []string{"sh", "-c", "sleep 10 & sleep 10"}, Options{})
+	must.ErrorIs(err, context.DeadlineExceeded)
+	must.Less(time.Since(start), 5*time.Second)
+
+	_, err = Run(context.Background(), []string{"keyswift-no-such-command"}, Options{})
+	must.Error(err)
+	_, err = Run(context.Background(), nil, Options{})
+	must.Error(err)
+}
+
+func TestStart(t *testing.T) {
+	must := require.New(t)
+	pid, err := Start([]string{"true"}, Options{}, true)
+	must.NoError(err)
+	must.Positive(pid)
+
+	_, err = Start([]string{"keyswift-no-such-command"}, Options{}, false)
+	must.True(err != nil && strings.Contains(err.Error(), "keyswift-no-such-command"))
+}
@@ -0,0 +1,129 @@
+package dbusclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+)
+
+var _ engine.DBus = (*Client)(nil)
+
+// Client calls the methods and subscribes the signals of the scripts on a D-Bus connection
+type Client struct {
+	conn    *dbus.Conn
+	signals chan *dbus.Signal
+
+	mu   sync.Mutex
+	subs []subscription
+}
+
+type subscription struct {
+	match engine.SignalMatch
+	// opts is the match rule added to the bus
+	opts    []dbus.MatchOption
+	handler func(engine.Signal)
+}
+
+// New creates a client on the connection, the signals are dispatched until the connection is closed
+func New(conn *dbus.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		signals: make(chan *dbus.Signal, 64),
+	}
+	conn.Signal(c.signals)
+	go c.dispatch()
+	return c
+}
+
+// Call calls the method, the arguments are converted by the signature or inferred if it's empty
+func (c *Client) Call(ctx context.Context, dest, path, iface, method, signature string, args []any) ([]any, error) {
+	values, err := toArgs(signature, args)
+	if err != nil {
+		return nil, err
+	}
+
+	call := c.conn.Object(dest, dbus.ObjectPath(path)).CallWithContext(ctx, iface+"."+method, 0, values...)
+	if call.Err != nil {
+		return nil, fmt.Errorf("failed to call %s.%s: %w", iface, method, call.Err)
+	}
+	return fromDBus(call.Body).([]any), nil
+}
+
+// Subscribe adds the match rule of the signals to the bus, the handler is called on the dispatching goroutine
+func (c *Client) Subscribe(match engine.SignalMatch, handler func(engine.Signal)) error {
+	opts := matchOptions(match)
+	if err := c.conn.AddMatchSignal(opts...); err != nil {
+		return fmt.Errorf("failed to add match rule: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs = append(c.subs, subscription{match: match, opts: opts, handler: handler})
+	return nil
+}
+
+// Reset drops the subscriptions and removes their match rules from the bus
+func (c *Client) Reset() {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.mu.Unlock()
+
+	// the replies are read by the goroutine delivering the signals, so the rules are removed without holding mu
+	for _, sub := range subs {
+		if err := c.conn.RemoveMatchSignal(sub.opts...); err != nil {
+			slog.Error("failed to remove match rule", "match", sub.match, "error", err)
+		}
+	}
+}
+
+// matchOptions returns the match rule of the signals, the empty fields match anything
+func matchOptions(match engine.SignalMatch) []dbus.MatchOption {
+	var opts []dbus.MatchOption
+	if match.Sender != "" {
+		opts = append(opts, dbus.WithMatchSender(match.Sender))
+	}
+	if match.Path != "" {
+		opts = append(opts, dbus.WithMatchObjectPath(dbus.ObjectPath(match.Path)))
+	}
+	if match.Interface != "" {
+		opts = append(opts, dbus.WithMatchInterface(match.Interface))
+	}
+	if match.Member != "" {
+		opts = append(opts, dbus.WithMatchMember(match.Member))
+	}
+	return opts
+}
+
+func (c *Client) dispatch() {
+	for sig := range c.signals {
+		dot := strings.LastIndexByte(sig.Name, '.')
+		if dot < 0 {
+			continue
+		}
+		s := engine.Signal{
+			Sender:    sig.Sender,
+			Path:      string(sig.Path),
+			Interface: sig.Name[:dot],
+			Member:    sig.Name[dot+1:],
+			Body:      fromDBus(sig.Body).([]any),
+		}
+		slog.Debug("dbus signal", "signal", s)
+
+		c.mu.Lock()
+		subs := slices.Clone(c.subs)
+		c.mu.Unlock()
+		for _, sub := range subs {
+			if sub.match.Match(s) {
+				sub.handler(s)
+			}
+		}
+	}
+}
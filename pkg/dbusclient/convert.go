@@ -0,0 +1,326 @@
+package dbusclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const basicTypes = "ybnqiuxtdsogh"
+
+// splitSignature splits the signature into single complete types
+func splitSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		n, err := typeLen(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, sig[:n])
+		sig = sig[n:]
+	}
+	return types, nil
+}
+
+// typeLen returns the length of the first single complete type of the signature
+func typeLen(sig string) (int, error) {
+	if sig == "" {
+		return 0, fmt.Errorf("incomplete signature")
+	}
+	switch c := sig[0]; {
+	case c == 'a':
+		n, err := typeLen(sig[1:])
+		if err != nil {
+			return 0, err
+		}
+		return 1 + n, nil
+	case c == '{' || c == '(':
+		end := byte('}')
+		if c == '(' {
+			end = ')'
+		}
+		i := 1
+		for i < len(sig) && sig[i] != end {
+			n, err := typeLen(sig[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+		if i == len(sig) {
+			return 0, fmt.Errorf("unclosed %c in signature", c)
+		}
+		return i + 1, nil
+	case c == 'v' || strings.IndexByte(basicTypes, c) >= 0:
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("unknown type %c in signature", c)
+	}
+}
+
+// goType returns the go type of a single complete type
+func goType(sig string) (reflect.Type, error) {
+	switch sig[0] {
+	case 'y':
+		return reflect.TypeOf(byte(0)), nil
+	case 'b':
+		return reflect.TypeOf(false), nil
+	case 'n':
+		return reflect.TypeOf(int16(0)), nil
+	case 'q':
+		return reflect.TypeOf(uint16(0)), nil
+	case 'i':
+		return reflect.TypeOf(int32(0)), nil
+	case 'u':
+		return reflect.TypeOf(uint32(0)), nil
+	case 'x':
+		return reflect.TypeOf(int64(0)), nil
+	case 't':
+		return reflect.TypeOf(uint64(0)), nil
+	case 'd':
+		return reflect.TypeOf(float64(0)), nil
+	case 's':
+		return reflect.TypeOf(""), nil
+	case 'o':
+		return reflect.TypeOf(dbus.ObjectPath("")), nil
+	case 'g':
+		return reflect.TypeOf(dbus.Signature{}), nil
+	case 'v':
+		return reflect.TypeOf(dbus.Variant{}), nil
+	case 'a':
+		if sig[1] == '{' {
+			key, err := goType(sig[2:3])
+			if err != nil {
+				return nil, err
+			}
+			value, err := goType(sig[3 : len(sig)-1])
+			if err != nil {
+				return nil, err
+			}
+			return reflect.MapOf(key, value), nil
+		}
+		elem, err := goType(sig[1:])
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	default:
+		return nil, fmt.Errorf("type %s is not supported", sig)
+	}
+}
+
+// toArgs converts the JSON values to the arguments of a method call,
+// the types are inferred if the signature is empty
+func toArgs(signature string, values []any) ([]any, error) {
+	args := make([]any, len(values))
+	if signature == "" {
+		for i, v := range values {
+			arg, err := infer(v)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
+			}
+			args[i] = arg
+		}
+		return args, nil
+	}
+
+	types, err := splitSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("signature %s has %d types, got %d arguments", signature, len(types), len(values))
+	}
+	for i, v := range values {
+		arg, err := convert(types[i], v)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+// infer converts a JSON value to a D-Bus value: strings, booleans, int32 or int64 for integers, doubles,
+// string arrays, variant arrays and a{sv} for objects
+func infer(v any) (any, error) {
+	switch v := v.(type) {
+	case string, bool:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			if i >= math.MinInt32 && i <= math.MaxInt32 {
+				return int32(i), nil
+			}
+			return i, nil
+		}
+		return v.Float64()
+	case []any:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if len(strs) == len(v) {
+			return strs, nil
+		}
+		variants := make([]dbus.Variant, len(v))
+		for i, item := range v {
+			inferred, err := infer(item)
+			if err != nil {
+				return nil, err
+			}
+			variants[i] = dbus.MakeVariant(inferred)
+		}
+		return variants, nil
+	case map[string]any:
+		m := make(map[string]dbus.Variant, len(v))
+		for k, item := range v {
+			inferred, err := infer(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = dbus.MakeVariant(inferred)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("can't infer the D-Bus type of %v", v)
+	}
+}
+
+// convert converts a JSON value to the single complete type
+func convert(sig string, v any) (any, error) {
+	t, err := goType(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sig[0] {
+	case 'n', 'i', 'x':
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		i, err := strconv.ParseInt(n.String(), 10, t.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("%v is not an integer of type %s", v, sig)
+		}
+		return reflect.ValueOf(i).Convert(t).Interface(), nil
+	case 'y', 'q', 'u', 't':
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		u, err := strconv.ParseUint(n.String(), 10, t.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("%v is not an integer of type %s", v, sig)
+		}
+		return reflect.ValueOf(u).Convert(t).Interface(), nil
+	case 'd':
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		return n.Float64()
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a boolean", v)
+		}
+		return b, nil
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", v)
+		}
+		return reflect.ValueOf(s).Convert(t).Interface(), nil
+	case 'g':
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", v)
+		}
+		return dbus.ParseSignature(s)
+	case 'v':
+		inferred, err := infer(v)
+		if err != nil {
+			return nil, err
+		}
+		return dbus.MakeVariant(inferred), nil
+	}
+
+	// arrays and dicts
+	if sig[1] == '{' {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%v is not an object", v)
+		}
+		m := reflect.MakeMapWithSize(t, len(obj))
+		for k, item := range obj {
+			key := any(k)
+			if sig[2] != 's' {
+				if key, err = convert(sig[2:3], json.Number(k)); err != nil {
+					return nil, err
+				}
+			}
+			value, err := convert(sig[3:len(sig)-1], item)
+			if err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+		}
+		return m.Interface(), nil
+	}
+
+	arr, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an array", v)
+	}
+	s := reflect.MakeSlice(t, 0, len(arr))
+	for _, item := range arr {
+		elem, err := convert(sig[1:], item)
+		if err != nil {
+			return nil, err
+		}
+		s = reflect.Append(s, reflect.ValueOf(elem))
+	}
+	return s.Interface(), nil
+}
+
+// fromDBus converts a D-Bus value to a JSON value, structs are arrays and the keys of dicts are strings
+func fromDBus(v any) any {
+	switch v := v.(type) {
+	case dbus.Variant:
+		return fromDBus(v.Value())
+	case dbus.ObjectPath:
+		return string(v)
+	case dbus.Signature:
+		return v.String()
+	case dbus.UnixFDIndex:
+		return uint32(v)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]any, rv.Len())
+		for i := range items {
+			items[i] = fromDBus(rv.Index(i).Interface())
+		}
+		return items
+	case reflect.Map:
+		m := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = fromDBus(iter.Value().Interface())
+		}
+		return m
+	default:
+		return v
+	}
+}
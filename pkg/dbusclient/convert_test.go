@@ -0,0 +1,74 @@
+package dbusclient
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonValues(t *testing.T, s string) []any {
+	var values []any
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&values))
+	return values
+}
+
+func TestToArgs(t *testing.T) {
+	must := require.New(t)
+
+	args, err := toArgs("", jsonValues(t, `["a", true, 1, 5000000000, 1.5, ["x", "y"], [1, "x"], {"k": 1}]`))
+	must.NoError(err)
+	must.Equal([]any{
+		"a", true, int32(1), int64(5000000000), 1.5,
+		[]string{"x", "y"},
+		[]dbus.Variant{dbus.MakeVariant(int32(1)), dbus.MakeVariant("x")},
+		map[string]dbus.Variant{"k": dbus.MakeVariant(int32(1))},
+	}, args)
+
+	// org.freedesktop.Notifications.Notify
+	args, err = toArgs("susssasa{sv}i", jsonValues(t, `["app", 0, "", "title", "body", [], {"urgency": 1}, 5000]`))
+	must.NoError(err)
+	must.Equal([]any{
+		"app", uint32(0), "", "title", "body", []string{},
+		map[string]dbus.Variant{"urgency": dbus.MakeVariant(int32(1))}, int32(5000),
+	}, args)
+
+	args, err = toArgs("oxya{ub}", jsonValues(t, `["/org/mpris/MediaPlayer2", -5, 255, {"1": true}]`))
+	must.NoError(err)
+	must.Equal([]any{dbus.ObjectPath("/org/mpris/MediaPlayer2"), int64(-5), byte(255), map[uint32]bool{1: true}}, args)
+
+	for _, c := range []struct{ sig, values string }{
+		{"u", `[-1]`},
+		{"y", `[256]`},
+		{"i", `[1.5]`},
+		{"s", `[1]`},
+		{"ss", `["a"]`},
+		{"(si)", `[["a", 1]]`},
+		{"a{sv", `[{}]`},
+		{"z", `[1]`},
+	} {
+		_, err = toArgs(c.sig, jsonValues(t, c.values))
+		must.Error(err, c.sig)
+	}
+}
+
+func TestFromDBus(t *testing.T) {
+	v := fromDBus([]any{
+		dbus.MakeVariant(map[string]dbus.Variant{"xesam:title": dbus.MakeVariant("song")}),
+		dbus.ObjectPath("/a"),
+		[]any{"struct", int32(1)},
+		map[uint32]string{1: "one"},
+		uint64(7),
+	})
+	require.Equal(t, []any{
+		map[string]any{"xesam:title": "song"},
+		"/a",
+		[]any{"struct", int32(1)},
+		map[string]any{"1": "one"},
+		uint64(7),
+	}, v)
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const DefaultComboTimeout = 30 * time.Millisecond
+
+func (e *QuickJS) Combos() []Combo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.combos)
+}
+
+func (e *QuickJS) RunCombo(session Bus, id int) error {
+	return e.do(func() error {
+		if id < 0 || id >= len(e.comboBindings) {
+			return fmt.Errorf("unknown combo %d", id)
+		}
+		return e.runBindings(session, e.comboBindings[id:id+1])
+	})
+}
+
+// registerCombo registers KeySwift.onCombo(keys, callback, {timeoutMs, devices})
+func (e *QuickJS) registerCombo(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncOnCombo, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncOnCombo) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("onCombo requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsArray() {
+			slog.Error("keys must be an array")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsFunction() {
+			slog.Error("onCombo requires a function as the second argument")
+			return ctx.Undefined()
+		}
+
+		codes, err := e.getKeyCodes(args[0])
+		if err != nil {
+			slog.Error("failed to get key codes", "error", err)
+			return ctx.Undefined()
+		}
+
+		if len(codes) < 2 {
+			slog.Error("a combo requires at least two keys")
+			return ctx.Undefined()
+		}
+
+		if slices.ContainsFunc(codes, keys.IsModifier) {
+			slog.Error("combo keys must not be modifiers, use onKeyPress instead")
+			return ctx.Undefined()
+		}
+
+		timeout := DefaultComboTimeout
+		var devices DeviceFilter
+		if len(args) == 3 && args[2].IsObject() {
+			timeout = optionDuration(args[2], "timeoutMs", DefaultComboTimeout)
+			if devices, err = optionDevices(args[2]); err != nil {
+				slog.Error("failed to parse devices", "error", err)
+				return ctx.Undefined()
+			}
+		}
+
+		combo := Combo{
+			ID:      len(e.comboBindings),
+			Keys:    codes,
+			Timeout: timeout,
+			Devices: devices,
+		}
+		slog.Debug("add combo", "combo", combo)
+		e.comboBindings = append(e.comboBindings, binding{fn: e.retain(args[1])})
+
+		e.mu.Lock()
+		e.combos = append(e.combos, combo)
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+const DefaultDBusTimeout = 5 * time.Second
+
+// signalBinding is a callback of KeySwift.dbus.onSignal
+type signalBinding struct {
+	match SignalMatch
+	// fn is only accessed on the js goroutine
+	fn quickjs.Value
+}
+
+// SetDBus sets the session bus and subscribes the signals registered by the script on it
+func (e *QuickJS) SetDBus(d DBus) {
+	e.mu.Lock()
+	e.dbus = d
+	matches := make([]SignalMatch, len(e.signals))
+	for i, b := range e.signals {
+		matches[i] = b.match
+	}
+	e.mu.Unlock()
+
+	if d == nil {
+		return
+	}
+	for i, match := range matches {
+		e.subscribe(d, i, match)
+	}
+}
+
+// subscribe subscribes the i-th signal binding, the callback is invoked on the js goroutine.
+// It's called without holding mu, adding the match rule is a round trip to the bus.
+func (e *QuickJS) subscribe(d DBus, i int, match SignalMatch) {
+	err := d.Subscribe(match, func(s Signal) {
+		_ = e.do(func() error {
+			e.fireSignal(i, s)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("failed to subscribe signal", "match", match, "error", err)
+	}
+}
+
+// fireSignal invokes the callback with the arguments and the signal, it runs on the js goroutine
+func (e *QuickJS) fireSignal(i int, s Signal) {
+	body, err := json.Marshal(s.Body)
+	if err != nil {
+		slog.Error("failed to convert signal", "signal", s, "error", err)
+		return
+	}
+	args := e.ctx.ParseJSON(string(body))
+	defer args.Free()
+
+	signal := e.ctx.Object()
+	defer signal.Free()
+	signal.Set("sender", e.ctx.String(s.Sender))
+	signal.Set("path", e.ctx.String(s.Path))
+	signal.Set("interface", e.ctx.String(s.Interface))
+	signal.Set("member", e.ctx.String(s.Member))
+
+	if err = e.invokeDetached(e.signals[i].fn, args, signal); err != nil {
+		slog.Error("signal callback failed", "error", err)
+	}
+}
+
+// jsonArgs converts the js array to JSON values, the numbers are kept as json.Number
+func (e *QuickJS) jsonArgs(v quickjs.Value) ([]any, error) {
+	if v.IsUndefined() {
+		return nil, nil
+	}
+	if !v.IsArray() {
+		return nil, errors.New("args must be an array")
+	}
+	data, err := e.stringify(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []any
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err = dec.Decode(&args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// dbusResult converts the reply of a method call: undefined for no value, the value for a single one,
+// or an array of the values
+func (e *QuickJS) dbusResult(body []any) func() quickjs.Value {
+	return func() quickjs.Value {
+		var v any = body
+		switch len(body) {
+		case 0:
+			return e.ctx.Undefined()
+		case 1:
+			v = body[0]
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			slog.Error("failed to convert reply", "error", err)
+			return e.ctx.Undefined()
+		}
+		return e.ctx.ParseJSON(string(data))
+	}
+}
+
+// registerDBus registers KeySwift.dbus.call(dest, path, iface, method, args, {signature, timeoutMs})
+// and KeySwift.dbus.onSignal({sender, path, interface, member}, callback)
+func (e *QuickJS) registerDBus(ctx *quickjs.Context, keySwift quickjs.Value) {
+	dbusObj := ctx.Object()
+	keySwift.Set(DBusObj, dbusObj)
+
+	dbusObj.Set(FuncDBusCall, ctx.AsyncFunction(func(ctx *quickjs.Context, this quickjs.Value, promise quickjs.Value, args []quickjs.Value) quickjs.Value {
+		id := e.pendPromise(promise)
+		if len(args) < 4 || len(args) > 6 {
+			e.settle(id, nil, errors.New("dbus.call requires dest, path, interface, method, args and options"))
+			return ctx.Undefined()
+		}
+
+		var callArgs []any
+		if len(args) > 4 {
+			var err error
+			if callArgs, err = e.jsonArgs(args[4]); err != nil {
+				e.settle(id, nil, err)
+				return ctx.Undefined()
+			}
+		}
+		var signature string
+		timeout := DefaultDBusTimeout
+		if len(args) == 6 && args[5].IsObject() {
+			sig := args[5].Get("signature")
+			if sig.IsString() {
+				signature = sig.String()
+			}
+			sig.Free()
+			timeout = optionDuration(args[5], "timeoutMs", DefaultDBusTimeout)
+		}
+
+		e.mu.RLock()
+		d := e.dbus
+		e.mu.RUnlock()
+		if d == nil {
+			e.settle(id, nil, errors.New("D-Bus is not connected"))
+			return ctx.Undefined()
+		}
+
+		dest, path, iface, method := args[0].String(), args[1].String(), args[2].String(), args[3].String()
+		go func() {
+			callCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			body, err := d.Call(callCtx, dest, path, iface, method, signature, callArgs)
+			e.settleLater(id, e.dbusResult(body), err)
+		}()
+		return ctx.Undefined()
+	}))
+
+	dbusObj.Set(FuncDBusOnSignal, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(DBusObj + "." + FuncDBusOnSignal) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 || !args[0].IsObject() || !args[1].IsFunction() {
+			slog.Error("dbus.onSignal requires a match rule and a callback")
+			return ctx.Undefined()
+		}
+
+		var match SignalMatch
+		for name, field := range map[string]*string{
+			"sender":    &match.Sender,
+			"path":      &match.Path,
+			"interface": &match.Interface,
+			"member":    &match.Member,
+		} {
+			v := args[0].Get(name)
+			if v.IsString() {
+				*field = v.String()
+			}
+			v.Free()
+		}
+		if match == (SignalMatch{}) {
+			slog.Error("dbus.onSignal requires at least one field in the match rule")
+			return ctx.Undefined()
+		}
+
+		e.mu.Lock()
+		e.signals = append(e.signals, signalBinding{match: match, fn: e.retain(args[1])})
+		i, d := len(e.signals)-1, e.dbus
+		e.mu.Unlock()
+		if d != nil {
+			e.subscribe(d, i, match)
+		}
+		return ctx.Undefined()
+	}))
+}
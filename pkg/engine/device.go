@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"log/slog"
+	"regexp"
+	"slices"
+
+	"github.com/buke/quickjs-go"
+)
+
+// DeviceFilter matches the input devices by their names or paths, an empty filter matches any
+type DeviceFilter []*regexp.Regexp
+
+// Match returns true if the filter is empty or the device matches one of its patterns
+func (f DeviceFilter) Match(d *Device) bool {
+	if len(f) == 0 {
+		return true
+	}
+	if d == nil {
+		return false
+	}
+	return slices.ContainsFunc(f, func(re *regexp.Regexp) bool {
+		return re.MatchString(d.Name) || re.MatchString(d.Path)
+	})
+}
+
+// optionDevices parses the devices option, a glob, a RegExp or an array of them
+func optionDevices(opts quickjs.Value) (DeviceFilter, error) {
+	v := opts.Get("devices")
+	defer v.Free()
+	return patterns(v)
+}
+
+// newDevice converts the device to {name, path}, null if it's nil
+func (e *QuickJS) newDevice(d *Device) quickjs.Value {
+	if d == nil {
+		return e.ctx.Null()
+	}
+	v := e.ctx.Object()
+	v.Set("name", e.ctx.String(d.Name))
+	v.Set("path", e.ctx.String(d.Path))
+	return v
+}
+
+// registerDevice registers KeySwift.getDevice()
+func (e *QuickJS) registerDevice(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncGetDevice, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if e.session == nil {
+			slog.Warn("getDevice should be called inside a callback")
+			return ctx.Null()
+		}
+		return e.newDevice(e.session.GetDevice())
+	}))
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/command"
+)
+
+const DefaultRunTimeout = 10 * time.Second
+
+// commandArgs converts a command line run by the shell or an array of arguments
+func commandArgs(v quickjs.Value) ([]string, error) {
+	if v.IsString() {
+		return []string{"sh", "-c", v.String()}, nil
+	}
+	args, err := getStrings(v)
+	if err != nil {
+		return nil, fmt.Errorf("command must be a string or an array of strings")
+	}
+	return args, nil
+}
+
+// commandOptions converts {cwd, env} of exec and run
+func commandOptions(opts quickjs.Value) (command.Options, error) {
+	var ret command.Options
+	cwd := opts.Get("cwd")
+	defer cwd.Free()
+	if cwd.IsString() {
+		ret.Dir = cwd.String()
+	}
+
+	env := opts.Get("env")
+	defer env.Free()
+	if !env.IsObject() {
+		return ret, nil
+	}
+	names, err := env.PropertyNames()
+	if err != nil {
+		return ret, err
+	}
+	ret.Env = make(map[string]string, len(names))
+	for _, name := range names {
+		v := env.Get(name)
+		ret.Env[name] = v.String()
+		v.Free()
+	}
+	return ret, nil
+}
+
+// runResult converts the result of run to {stdout, stderr, code}
+func (e *QuickJS) runResult(res command.Result) func() quickjs.Value {
+	return func() quickjs.Value {
+		result := e.ctx.Object()
+		result.Set("stdout", e.ctx.String(res.Stdout))
+		result.Set("stderr", e.ctx.String(res.Stderr))
+		result.Set("code", e.ctx.Int32(int32(res.Code)))
+		return result
+	}
+}
+
+// registerExec registers KeySwift.exec(command, {detach, cwd, env}) and KeySwift.run(command, {timeoutMs, cwd, env}),
+// the commands run in the background and never block the key events
+func (e *QuickJS) registerExec(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncExec, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("exec requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		argv, err := commandArgs(args[0])
+		if err != nil {
+			slog.Error("failed to exec", "error", err)
+			return ctx.Undefined()
+		}
+
+		var opts command.Options
+		var detach bool
+		if len(args) == 2 && args[1].IsObject() {
+			if opts, err = commandOptions(args[1]); err != nil {
+				slog.Error("failed to exec", "error", err)
+				return ctx.Undefined()
+			}
+			detach = optionBool(args[1], "detach")
+		}
+
+		pid, err := command.Start(argv, opts, detach)
+		if err != nil {
+			slog.Error("failed to exec", "error", err)
+			return ctx.Undefined()
+		}
+		return ctx.Int32(int32(pid))
+	}))
+
+	keySwift.Set(FuncRun, ctx.AsyncFunction(func(ctx *quickjs.Context, this quickjs.Value, promise quickjs.Value, args []quickjs.Value) quickjs.Value {
+		id := e.pendPromise(promise)
+
+		if len(args) != 1 && len(args) != 2 {
+			e.settle(id, nil, fmt.Errorf("run requires one or two arguments"))
+			return ctx.Undefined()
+		}
+
+		argv, err := commandArgs(args[0])
+		if err != nil {
+			e.settle(id, nil, err)
+			return ctx.Undefined()
+		}
+
+		opts := command.Options{}
+		timeout := DefaultRunTimeout
+		if len(args) == 2 && args[1].IsObject() {
+			if opts, err = commandOptions(args[1]); err != nil {
+				e.settle(id, nil, err)
+				return ctx.Undefined()
+			}
+			timeout = optionDuration(args[1], "timeoutMs", DefaultRunTimeout)
+		}
+
+		go func() {
+			runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := command.Run(runCtx, argv, opts)
+			e.settleLater(id, e.runResult(res), err)
+		}()
+		return ctx.Undefined()
+	}))
+}
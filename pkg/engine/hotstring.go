@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/buke/quickjs-go"
+)
+
+func (e *QuickJS) Hotstrings() []Hotstring {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.hotstrings)
+}
+
+// optionStrings returns a property of a js options object which is a string or an array of strings
+func optionStrings(opts quickjs.Value, name string) ([]string, error) {
+	v := opts.Get(name)
+	defer v.Free()
+	switch {
+	case v.IsUndefined():
+		return nil, nil
+	case v.IsString():
+		return []string{v.String()}, nil
+	default:
+		return getStrings(v)
+	}
+}
+
+// registerHotstring registers KeySwift.hotstring(trigger, replacement, {immediate, windowClass})
+func (e *QuickJS) registerHotstring(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncHotstring, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncHotstring) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("hotstring requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsString() || args[0].String() == "" {
+			slog.Error("hotstring requires a trigger string as the first argument")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsString() {
+			slog.Error("hotstring requires a replacement string as the second argument")
+			return ctx.Undefined()
+		}
+
+		hs := Hotstring{
+			Trigger:     args[0].String(),
+			Replacement: args[1].String(),
+		}
+		if len(args) == 3 && args[2].IsObject() {
+			hs.Immediate = optionBool(args[2], "immediate")
+			classes, err := optionStrings(args[2], "windowClass")
+			if err != nil {
+				slog.Error("failed to get window classes", "error", err)
+				return ctx.Undefined()
+			}
+			hs.WindowClasses = classes
+		}
+		slog.Debug("add hotstring", "hotstring", hs)
+
+		e.mu.Lock()
+		e.hotstrings = append(e.hotstrings, hs)
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+}
@@ -1,6 +1,11 @@
 package engine
 
 import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/jialeicui/keyswift/pkg/keys"
 )
 
@@ -8,17 +13,340 @@ const (
 	FuncGetActiveWindowClass = "getActiveWindowClass"
 	FuncSendKeys             = "sendKeys"
 	FuncOnKeyPress           = "onKeyPress"
+	FuncOnKeyRelease         = "onKeyRelease"
+	FuncOnTapHold            = "onTapHold"
+	FuncOnSequence           = "onSequence"
+	FuncSetLeader            = "setLeader"
+	FuncOnCombo              = "onCombo"
+	FuncLayer                = "layer"
+	FuncActivateLayer        = "activateLayer"
+	FuncDeactivateLayer      = "deactivateLayer"
+	FuncToggleLayer          = "toggleLayer"
+	FuncOneShotLayer         = "oneShotLayer"
+	FuncGetActiveLayers      = "getActiveLayers"
+	FuncSetStickyModifiers   = "setStickyModifiers"
+	FuncHotstring            = "hotstring"
+	FuncTypeUnicode          = "typeUnicode"
+	FuncTypeText             = "typeText"
+	FuncSetLayout            = "setLayout"
+	FuncMacro                = "macro"
+	FuncSetUnicodeMethod     = "setUnicodeMethod"
+	FuncStartRecording       = "startRecording"
+	FuncStopRecording        = "stopRecording"
+	FuncIsRecording          = "isRecording"
+	FuncReplay               = "replay"
+	FuncSetKeyRepeat         = "setKeyRepeat"
+	FuncExec                 = "exec"
+	FuncRun                  = "run"
+	FuncOnWindowFocus        = "onWindowFocus"
+	FuncGetActiveWindow      = "getActiveWindow"
+	FuncWhen                 = "when"
+	FuncGetDevice            = "getDevice"
+	FuncOnModifierTap        = "onModifierTap"
+	FuncSuppressModifierTaps = "suppressModifierTaps"
+	FuncSetModifierPolicy    = "setModifierPolicy"
+
+	// StoreObj is the key-value store under KeySwift
+	StoreObj        = "store"
+	FuncStoreGet    = "get"
+	FuncStoreSet    = "set"
+	FuncStoreDelete = "delete"
+
+	// DBusObj is the session bus under KeySwift
+	DBusObj          = "dbus"
+	FuncDBusCall     = "call"
+	FuncDBusOnSignal = "onSignal"
 
 	KeySwiftObj = "KeySwift"
 )
 
 type Engine interface {
+	// Run dispatches the event of the session to the callbacks registered by the script
 	Run(session Bus) error
+	// ExpireSequence ends the pending key sequence after its timeout
+	ExpireSequence(session Bus) error
+	// TapHold returns the tap-hold binding of the key
+	TapHold(key keys.Key) (TapHold, bool)
+	// RunWindowFocus invokes the callbacks of the window focus changes, prev is nil for the first window
+	RunWindowFocus(session Bus, win, prev *Window) error
+	// Combos returns the combos registered by the script
+	Combos() []Combo
+	// RunCombo invokes the callback of the combo
+	RunCombo(session Bus, id int) error
+	// Layer returns the layer defined by the script
+	Layer(name string) (Layer, bool)
+	// LayerKey returns the layer activated by the key
+	LayerKey(key keys.Key) (LayerKey, bool)
+	// IsStickyModifier returns true if tapping the modifier arms it for the next key
+	IsStickyModifier(key keys.Key) bool
+	// ModifierTap returns the binding of the modifier tapped alone
+	ModifierTap(key keys.Key) (ModifierTap, bool)
+	// RunModifierTap invokes the callback of the modifier tap
+	RunModifierTap(session Bus, id int) error
+	// IsTapSuppressed returns true if the system must not see the modifier tapped alone
+	IsTapSuppressed(key keys.Key) bool
+	// ModifierPolicy returns when the modifier pressed in the window is forwarded
+	ModifierPolicy(key keys.Key, windowClass string) ModifierPolicy
+	// Hotstrings returns the hotstrings registered by the script
+	Hotstrings() []Hotstring
+	// UnicodeMethod returns how the characters not on the keyboard are typed in the window
+	UnicodeMethod(windowClass string) UnicodeMethod
+	// KeyboardLayout returns the XKB layout set by the script, empty means the system layout
+	KeyboardLayout() (name, variant string)
+	// KeyRepeat returns how the keys sent for a held chord are repeated
+	KeyRepeat() KeyRepeat
+	// SetDBus sets the session bus of the scripts, the signals are subscribed again on it
+	SetDBus(d DBus)
+	// SetBusFactory sets the factory of the bus used by the callbacks not triggered by key events, like timers
+	SetBusFactory(factory func() Bus)
+	// Release stops the engine and frees the js runtime
 	Release()
 }
 
 type Bus interface {
 	GetActiveWindowClass() string
+	// GetActiveWindow returns the active window, nil if it's unknown
+	GetActiveWindow() *Window
+	// GetDevice returns the input device of the event, nil if the event doesn't come from a device
+	GetDevice() *Device
+	// GetPressedKeys returns the pressed keys, for release it's the keys pressed before the release
 	GetPressedKeys() []keys.Key
+	GetKeyEventType() KeyEventType
+	GetEventTime() time.Time
 	SendKeys(keys []keys.Key)
+	// TypeText types the text on the keyboard layout, the characters not on it are typed by their code points
+	TypeText(text string)
+	// TypeUnicode types the text by the code points of the characters
+	TypeUnicode(text string)
+	// RunMacro runs the steps in order in the background, each key event is sent in its own frame after keyDelay
+	RunMacro(steps []MacroStep, keyDelay time.Duration)
+
+	// StartRecording records the key events sent to the virtual keyboard into the register
+	StartRecording(register string, persist bool) error
+	// StopRecording stops the recording and stores it into its register
+	StopRecording() error
+	IsRecording() bool
+	// Replay sends the recorded key events of the register in the background
+	Replay(register string, opts ReplayOptions) error
+
+	// PendSequence holds the event back as the prefix of a key sequence
+	// until the next chord arrives or the timeout passes
+	PendSequence(timeout time.Duration)
+	// CompleteSequence marks the event as the end of a key sequence
+	CompleteSequence()
+	// AbortSequence drops the pending key sequence, the events held back should be replayed
+	AbortSequence()
+
+	// GetActiveLayers returns the active layers, the last one is on top
+	GetActiveLayers() []string
+	ActivateLayer(name string, mode LayerMode)
+	DeactivateLayer(name string)
+}
+
+// Device is an input device
+type Device struct {
+	Name string
+	Path string
+}
+
+// Window is the active window
+type Window struct {
+	Title string
+	Class string
+}
+
+// DBus is the session bus used by the scripts, the values are JSON values
+type DBus interface {
+	// Call calls the method, the arguments are converted by the signature or inferred if it's empty
+	Call(ctx context.Context, dest, path, iface, method, signature string, args []any) ([]any, error)
+	// Subscribe calls the handler for the signals matching the rule
+	Subscribe(match SignalMatch, handler func(Signal)) error
+}
+
+// SignalMatch is the match rule of D-Bus signals, the empty fields match any value
+type SignalMatch struct {
+	// Sender is filtered by the bus, it's only checked locally if it's a unique name like ":1.42"
+	// because the signals carry the unique name of the sender
+	Sender    string
+	Path      string
+	Interface string
+	Member    string
+}
+
+func (m SignalMatch) Match(s Signal) bool {
+	matches := func(rule, value string) bool {
+		return rule == "" || rule == value
+	}
+	if strings.HasPrefix(m.Sender, ":") && m.Sender != s.Sender {
+		return false
+	}
+	return matches(m.Path, s.Path) && matches(m.Interface, s.Interface) && matches(m.Member, s.Member)
+}
+
+// Signal is a D-Bus signal
+type Signal struct {
+	Sender    string
+	Path      string
+	Interface string
+	Member    string
+	Body      []any
+}
+
+// KeyEventType is the type of the key event being dispatched
+type KeyEventType int
+
+const (
+	KeyEventPress KeyEventType = iota
+	KeyEventRelease
+	KeyEventRepeat
+)
+
+func (t KeyEventType) String() string {
+	switch t {
+	case KeyEventRelease:
+		return "release"
+	case KeyEventRepeat:
+		return "repeat"
+	default:
+		return "press"
+	}
+}
+
+// TapHold makes a key act as Tap when tapped and as Hold when held
+type TapHold struct {
+	Key  keys.Key
+	Tap  []keys.Key
+	Hold []keys.Key
+	// Timeout is the time after which a press is resolved as hold
+	Timeout time.Duration
+	// PermissiveHold resolves as hold when another key is pressed and released during the press
+	PermissiveHold bool
+	// HoldOnOtherKeyPress resolves as hold as soon as another key is pressed during the press
+	HoldOnOtherKeyPress bool
+}
+
+// ModifierTap is a modifier pressed and released alone within Timeout
+type ModifierTap struct {
+	// ID identifies the callback of the tap in RunModifierTap
+	ID      int
+	Key     keys.Key
+	Timeout time.Duration
+}
+
+// Combo is a set of ordinary keys pressed together within Timeout
+type Combo struct {
+	// ID identifies the callback of the combo in RunCombo
+	ID      int
+	Keys    []keys.Key
+	Timeout time.Duration
+	// Devices are the devices the combo is pressed on
+	Devices DeviceFilter
+}
+
+// LayerMode is how a layer is activated
+type LayerMode int
+
+const (
+	// LayerMomentary activates the layer until it's deactivated, i.e. while the layer key is held
+	LayerMomentary LayerMode = iota
+	// LayerToggle switches the layer on and off
+	LayerToggle
+	// LayerOneShot activates the layer for the next key press only
+	LayerOneShot
+)
+
+// Layer remaps keys while it's active, the keys not in Keys fall through to the layers below
+type Layer struct {
+	Name string
+	Keys map[keys.Key][]keys.Key
+}
+
+// LayerKey is a key activating a layer
+type LayerKey struct {
+	Key   keys.Key
+	Layer string
+	Mode  LayerMode
+}
+
+// Hotstring replaces the Trigger typed with Replacement
+type Hotstring struct {
+	Trigger     string
+	Replacement string
+	// Immediate expands as soon as the trigger is typed instead of after a terminating key
+	Immediate bool
+	// WindowClasses limits the hotstring to the windows, empty means all windows
+	WindowClasses []string
+}
+
+// Match returns true if the hotstring is enabled in the window
+func (h Hotstring) Match(windowClass string) bool {
+	return len(h.WindowClasses) == 0 || slices.Contains(h.WindowClasses, windowClass)
+}
+
+// UnicodeMethod is how a character is typed by its code point
+type UnicodeMethod string
+
+const (
+	// UnicodeCtrlShiftU is the IBus and GTK input: ctrl+shift+u, the hex digits and space
+	UnicodeCtrlShiftU UnicodeMethod = "ctrl-shift-u"
+	// UnicodeCtrlShiftUEnter commits the hex digits with enter instead of space
+	UnicodeCtrlShiftUEnter UnicodeMethod = "ctrl-shift-u-enter"
+)
+
+func (m UnicodeMethod) Valid() bool {
+	return m == UnicodeCtrlShiftU || m == UnicodeCtrlShiftUEnter
+}
+
+// ModifierPolicy is when a modifier pressed on the keyboard is forwarded
+type ModifierPolicy string
+
+const (
+	// ModifierEager forwards the modifier as soon as it's pressed,
+	// it's released before the keys sent by the callbacks
+	ModifierEager ModifierPolicy = "eager"
+	// ModifierLazy holds the modifier back until the chord resolves, it's forwarded if the chord is typed through
+	ModifierLazy ModifierPolicy = "lazy"
+	// ModifierNever never forwards the modifier, it's only seen by the callbacks
+	ModifierNever ModifierPolicy = "never"
+)
+
+func (p ModifierPolicy) Valid() bool {
+	return p == ModifierEager || p == ModifierLazy || p == ModifierNever
+}
+
+// MacroAction is the action of a macro step
+type MacroAction int
+
+const (
+	MacroPress MacroAction = iota
+	MacroRelease
+	// MacroTap presses the keys in order and releases them in the reverse order
+	MacroTap
+	MacroDelay
+	// MacroText types the text with the keyboard layout
+	MacroText
+	// MacroUnicode types the text by the code points of the characters
+	MacroUnicode
+)
+
+// MacroStep is a step of a macro
+type MacroStep struct {
+	Action MacroAction
+	Keys   []keys.Key
+	Text   string
+	Delay  time.Duration
+}
+
+// ReplayOptions controls the timing of a replay
+type ReplayOptions struct {
+	// OriginalTiming keeps the gaps between the recorded events, otherwise KeyDelay follows each event
+	OriginalTiming bool
+	KeyDelay       time.Duration
+}
+
+// KeyRepeat is the auto-repeat of the keys sent by the callbacks while the chord is held
+type KeyRepeat struct {
+	Enabled  bool
+	Delay    time.Duration
+	Interval time.Duration
 }
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+func (e *QuickJS) Layer(name string) (Layer, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	l, ok := e.layers[name]
+	return l, ok
+}
+
+func (e *QuickJS) LayerKey(key keys.Key) (LayerKey, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	lk, ok := e.layerKeys[key]
+	return lk, ok
+}
+
+// parseLayerKeys converts the mappings of a layer like {h: "left", n: "ctrl+left", m: ["shift", "end"]}
+func (e *QuickJS) parseLayerKeys(mappings quickjs.Value) (map[keys.Key][]keys.Key, error) {
+	names, err := mappings.PropertyNames()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[keys.Key][]keys.Key, len(names))
+	for _, name := range names {
+		from, err := keys.GetKeyCodes([]string{name})
+		if err != nil {
+			return nil, err
+		}
+
+		v := mappings.Get(name)
+		to, err := e.parseSequenceStep(v)
+		v.Free()
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", name, err)
+		}
+		ret[from[0]] = to.keys
+	}
+	return ret, nil
+}
+
+// registerLayer registers KeySwift.layer(name, mappings, {hold, toggle, oneShot})
+// and the functions changing the active layers from the callbacks
+func (e *QuickJS) registerLayer(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncLayer, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncLayer) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("layer requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsString() {
+			slog.Error("layer requires a name as the first argument")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsObject() {
+			slog.Error("layer requires a mappings object as the second argument")
+			return ctx.Undefined()
+		}
+
+		l := Layer{Name: args[0].String()}
+		var err error
+		l.Keys, err = e.parseLayerKeys(args[1])
+		if err != nil {
+			slog.Error("failed to parse layer", "layer", l.Name, "error", err)
+			return ctx.Undefined()
+		}
+
+		var layerKeys []LayerKey
+		if len(args) == 3 && args[2].IsObject() {
+			for option, mode := range map[string]LayerMode{"hold": LayerMomentary, "toggle": LayerToggle, "oneShot": LayerOneShot} {
+				v := args[2].Get(option)
+				if v.IsString() {
+					key, err := keys.GetKeyCodes([]string{v.String()})
+					if err != nil {
+						v.Free()
+						slog.Error("failed to get layer key", "layer", l.Name, "option", option, "error", err)
+						return ctx.Undefined()
+					}
+					layerKeys = append(layerKeys, LayerKey{Key: key[0], Layer: l.Name, Mode: mode})
+				}
+				v.Free()
+			}
+		}
+		slog.Debug("add layer", "layer", l, "keys", layerKeys)
+
+		e.mu.Lock()
+		e.layers[l.Name] = l
+		for _, lk := range layerKeys {
+			e.layerKeys[lk.Key] = lk
+		}
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+
+	for name, mode := range map[string]LayerMode{
+		FuncActivateLayer: LayerMomentary,
+		FuncToggleLayer:   LayerToggle,
+		FuncOneShotLayer:  LayerOneShot,
+	} {
+		keySwift.Set(name, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+			if len(args) != 1 || !args[0].IsString() {
+				slog.Error(name + " requires a layer name")
+				return ctx.Undefined()
+			}
+			if e.session == nil {
+				slog.Error(name + " should be called inside a callback")
+				return ctx.Undefined()
+			}
+			e.session.ActivateLayer(args[0].String(), mode)
+			return ctx.Undefined()
+		}))
+	}
+
+	keySwift.Set(FuncDeactivateLayer, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 || !args[0].IsString() {
+			slog.Error("deactivateLayer requires a layer name")
+			return ctx.Undefined()
+		}
+		if e.session == nil {
+			slog.Error("deactivateLayer should be called inside a callback")
+			return ctx.Undefined()
+		}
+		e.session.DeactivateLayer(args[0].String())
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncGetActiveLayers, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		names := ctx.Array().ToValue()
+		if e.session == nil {
+			slog.Warn("getActiveLayers should be called inside a callback")
+			return names
+		}
+		for i, name := range e.session.GetActiveLayers() {
+			names.SetIdx(int64(i), ctx.String(name))
+		}
+		return names
+	}))
+}
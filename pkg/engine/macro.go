@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const DefaultMacroKeyDelay = 5 * time.Millisecond
+
+// macroActions are the step properties of KeySwift.macro
+var macroActions = map[string]MacroAction{
+	"press":   MacroPress,
+	"release": MacroRelease,
+	"tap":     MacroTap,
+	"delay":   MacroDelay,
+	"text":    MacroText,
+	"unicode": MacroUnicode,
+}
+
+// macroKeys converts "ctrl", "ctrl+k" or ["ctrl", "k"] to key codes keeping the order
+func macroKeys(v quickjs.Value) ([]keys.Key, error) {
+	if v.IsString() {
+		return keys.GetKeyCodes(strings.Split(v.String(), "+"))
+	}
+	names, err := getStrings(v)
+	if err != nil {
+		return nil, err
+	}
+	return keys.GetKeyCodes(names)
+}
+
+// parseMacroStep converts a step like {press: "ctrl"}, {tap: ["ctrl", "k"]}, {delay: 50} or {text: "..."}
+func parseMacroStep(v quickjs.Value) (MacroStep, error) {
+	if !v.IsObject() {
+		return MacroStep{}, fmt.Errorf("step must be an object")
+	}
+	names, err := v.PropertyNames()
+	if err != nil {
+		return MacroStep{}, err
+	}
+	if len(names) != 1 {
+		return MacroStep{}, fmt.Errorf("step must have exactly one action, got %v", names)
+	}
+
+	action, ok := macroActions[names[0]]
+	if !ok {
+		return MacroStep{}, fmt.Errorf("unknown action %s", names[0])
+	}
+
+	step := MacroStep{Action: action}
+	arg := v.Get(names[0])
+	defer arg.Free()
+	switch action {
+	case MacroDelay:
+		if !arg.IsNumber() {
+			return MacroStep{}, fmt.Errorf("delay must be a number of milliseconds")
+		}
+		step.Delay = time.Duration(arg.Float64() * float64(time.Millisecond))
+	case MacroText, MacroUnicode:
+		if !arg.IsString() {
+			return MacroStep{}, fmt.Errorf("%s must be a string", names[0])
+		}
+		step.Text = arg.String()
+	default:
+		step.Keys, err = macroKeys(arg)
+		if err != nil {
+			return MacroStep{}, err
+		}
+	}
+	return step, nil
+}
+
+// registerMacro registers KeySwift.macro(steps, {keyDelayMs})
+func (e *QuickJS) registerMacro(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncMacro, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("macro requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsArray() {
+			slog.Error("macro requires an array of steps as the first argument")
+			return ctx.Undefined()
+		}
+
+		if e.session == nil {
+			slog.Error("macro should be called inside a callback")
+			return ctx.Undefined()
+		}
+
+		jsSteps := args[0].ToArray()
+		steps := make([]MacroStep, 0, jsSteps.Len())
+		for i := int64(0); i < jsSteps.Len(); i++ {
+			item, err := jsSteps.Get(i)
+			if err != nil {
+				slog.Error("failed to get step by index", "error", err, "index", i)
+				return ctx.Undefined()
+			}
+			step, err := parseMacroStep(item)
+			item.Free()
+			if err != nil {
+				slog.Error("failed to parse macro step", "error", fmt.Errorf("step %d: %w", i, err))
+				return ctx.Undefined()
+			}
+			steps = append(steps, step)
+		}
+
+		keyDelay := DefaultMacroKeyDelay
+		if len(args) == 2 && args[1].IsObject() {
+			keyDelay = optionDuration(args[1], "keyDelayMs", DefaultMacroKeyDelay)
+		}
+
+		e.session.RunMacro(steps, keyDelay)
+		return ctx.Undefined()
+	}))
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+func (e *QuickJS) ModifierPolicy(key keys.Key, windowClass string) ModifierPolicy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if policy, ok := e.classModifierPolicies[windowClass][key]; ok {
+		return policy
+	}
+	if policy, ok := e.modifierPolicies[key]; ok {
+		return policy
+	}
+	return defaultModifierPolicy(key)
+}
+
+// defaultModifierPolicy forwards ctrl and alt eagerly for ctrl+click and alt+drag with the mouse
+func defaultModifierPolicy(key keys.Key) ModifierPolicy {
+	switch key {
+	case golibevdev.KeyLeftCtrl, golibevdev.KeyRightCtrl, golibevdev.KeyLeftAlt, golibevdev.KeyRightAlt:
+		return ModifierEager
+	default:
+		return ModifierLazy
+	}
+}
+
+// registerModifierPolicy registers KeySwift.setModifierPolicy(keys, policy, {windowClass})
+func (e *QuickJS) registerModifierPolicy(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncSetModifierPolicy, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetModifierPolicy) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("setModifierPolicy requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		names, err := getStrings(args[0])
+		if err != nil {
+			slog.Error("failed to get key names", "error", err)
+			return ctx.Undefined()
+		}
+
+		codes, err := e.modifierCodes(names)
+		if err != nil {
+			slog.Error("failed to get modifiers", "error", err)
+			return ctx.Undefined()
+		}
+
+		policy := ModifierPolicy(args[1].String())
+		if !policy.Valid() {
+			slog.Error("unknown modifier policy", "policy", policy)
+			return ctx.Undefined()
+		}
+
+		var classes []string
+		if len(args) == 3 && args[2].IsObject() {
+			classes, err = optionStrings(args[2], "windowClass")
+			if err != nil {
+				slog.Error("failed to get window classes", "error", err)
+				return ctx.Undefined()
+			}
+		}
+		slog.Debug("set modifier policy", "keys", codes, "policy", policy, "windowClasses", classes)
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for _, code := range codes {
+			if len(classes) == 0 {
+				e.modifierPolicies[code] = policy
+			}
+			for _, class := range classes {
+				if e.classModifierPolicies[class] == nil {
+					e.classModifierPolicies[class] = map[keys.Key]ModifierPolicy{}
+				}
+				e.classModifierPolicies[class][code] = policy
+			}
+		}
+		return ctx.Undefined()
+	}))
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const DefaultModifierTapTimeout = 500 * time.Millisecond
+
+func (e *QuickJS) ModifierTap(key keys.Key) (ModifierTap, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	tap, ok := e.modifierTaps[key]
+	return tap, ok
+}
+
+func (e *QuickJS) RunModifierTap(session Bus, id int) error {
+	return e.do(func() error {
+		if id < 0 || id >= len(e.modifierTapBindings) {
+			return fmt.Errorf("unknown modifier tap %d", id)
+		}
+		return e.runBindings(session, e.modifierTapBindings[id:id+1])
+	})
+}
+
+func (e *QuickJS) IsTapSuppressed(key keys.Key) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.suppressedTaps[key]
+	return ok
+}
+
+// modifierCodes converts the names of modifiers to key codes, the generic names like "ctrl" are both sides
+func (e *QuickJS) modifierCodes(names []string) ([]keys.Key, error) {
+	var codes []keys.Key
+	for _, name := range names {
+		code, err := e.keyCodes([]string{name})
+		if err != nil {
+			return nil, err
+		}
+		if !keys.IsModifier(code[0]) {
+			return nil, fmt.Errorf("%s is not a modifier", name)
+		}
+		if keys.IsGeneric(name) {
+			codes = append(codes, keys.Sides(code[0])...)
+		} else {
+			codes = append(codes, code[0])
+		}
+	}
+	return codes, nil
+}
+
+// registerModifierTap registers KeySwift.onModifierTap(key, callback, {timeoutMs})
+// and KeySwift.suppressModifierTaps(keys)
+func (e *QuickJS) registerModifierTap(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncOnModifierTap, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncOnModifierTap) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("onModifierTap requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsString() {
+			slog.Error("onModifierTap requires a key name as the first argument")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsFunction() {
+			slog.Error("onModifierTap requires a function as the second argument")
+			return ctx.Undefined()
+		}
+
+		codes, err := e.modifierCodes([]string{args[0].String()})
+		if err != nil {
+			slog.Error("failed to get modifier", "error", err)
+			return ctx.Undefined()
+		}
+
+		timeout := DefaultModifierTapTimeout
+		if len(args) == 3 && args[2].IsObject() {
+			timeout = optionDuration(args[2], "timeoutMs", DefaultModifierTapTimeout)
+		}
+
+		id := len(e.modifierTapBindings)
+		e.modifierTapBindings = append(e.modifierTapBindings, binding{fn: e.retain(args[1])})
+
+		e.mu.Lock()
+		for _, code := range codes {
+			tap := ModifierTap{ID: id, Key: code, Timeout: timeout}
+			slog.Debug("add modifier tap", "tap", tap)
+			e.modifierTaps[code] = tap
+		}
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncSuppressModifierTaps, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSuppressModifierTaps) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 {
+			slog.Error("suppressModifierTaps requires one argument")
+			return ctx.Undefined()
+		}
+
+		names, err := getStrings(args[0])
+		if err != nil {
+			slog.Error("failed to get key names", "error", err)
+			return ctx.Undefined()
+		}
+
+		codes, err := e.modifierCodes(names)
+		if err != nil {
+			slog.Error("failed to get modifiers", "error", err)
+			return ctx.Undefined()
+		}
+
+		suppressed := make(map[keys.Key]struct{}, len(codes))
+		for _, code := range codes {
+			suppressed[code] = struct{}{}
+		}
+		slog.Debug("suppress modifier taps", "keys", codes)
+
+		e.mu.Lock()
+		e.suppressedTaps = suppressed
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// importRe matches the specifiers of the static imports, the re-exports and the dynamic imports of literal paths
+var importRe = regexp.MustCompile(`(?m)(?:^|[;}\s])(?:import|export)\s*(?:[\w*{}\s,$]*?\s*from\s*)?["']([^"']+)["']|\bimport\s*\(\s*["']([^"']+)["']\s*\)`)
+
+// computedImportRe matches the dynamic imports of a path that isn't a literal, which can't be resolved before they run
+var computedImportRe = regexp.MustCompile(`\bimport\s*\(\s*[^"'\s]`)
+
+// specifiers returns the modules imported by the source, resolved the way the module loader does:
+// the relative ones against the importing file and the others as is
+func specifiers(path, source string) []string {
+	var names []string
+	for _, m := range importRe.FindAllStringSubmatch(source, -1) {
+		name := m[1] + m[2]
+		if strings.HasPrefix(name, ".") {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// checkModules returns an error if the script or a module it imports uses the builtin std or os module.
+// Their timers and handlers keep the event loop of quickjs running, which would block the engine,
+// so the dynamic imports of computed paths are refused as well.
+func checkModules(path, script string) error {
+	files := []string{path}
+	seen := map[string]bool{path: true}
+	for i, source := 0, script; i < len(files); i++ {
+		if i > 0 {
+			data, err := os.ReadFile(files[i])
+			if err != nil {
+				// the module loader reports the missing modules
+				continue
+			}
+			source = string(data)
+		}
+		if computedImportRe.MatchString(source) {
+			return fmt.Errorf("%s imports a computed path, only the literal paths are supported", files[i])
+		}
+		for _, name := range specifiers(files[i], source) {
+			if name == "std" || name == "os" {
+				return fmt.Errorf("%s imports the %s module, which isn't supported", files[i], name)
+			}
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ModuleFiles returns the config and the files of the modules it imports, directly or not.
+// The imports are resolved the way the module loader does, the relative ones against the importing file
+// and the others as is, except the builtin std and os modules.
+// The missing modules are returned as well, so they can be watched until they're created.
+func ModuleFiles(path string) []string {
+	files := []string{path}
+	seen := map[string]bool{path: true}
+	for i := 0; i < len(files); i++ {
+		data, err := os.ReadFile(files[i])
+		if err != nil {
+			continue
+		}
+		for _, name := range specifiers(files[i], string(data)) {
+			if name == "std" || name == "os" {
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"github.com/buke/quickjs-go"
+)
+
+// pendPromise keeps the promise of an async function until it's settled, it runs on the js goroutine
+func (e *QuickJS) pendPromise(promise quickjs.Value) int32 {
+	e.nextPromiseID++
+	id := e.nextPromiseID
+	e.promises[id] = e.retain(promise)
+	return id
+}
+
+// settle rejects the pending promise if err is not nil, or resolves it with the value made by result.
+// It runs on the js goroutine.
+func (e *QuickJS) settle(id int32, result func() quickjs.Value, err error) {
+	promise, ok := e.promises[id]
+	if !ok {
+		return
+	}
+	delete(e.promises, id)
+	defer promise.Free()
+
+	var v quickjs.Value
+	if err != nil {
+		v = e.ctx.Error(err)
+	} else {
+		v = result()
+	}
+	defer v.Free()
+
+	method := "resolve"
+	if err != nil {
+		method = "reject"
+	}
+	promise.Call(method, v).Free()
+}
+
+// settleLater settles the pending promise on the js goroutine, it's called by the background goroutines
+func (e *QuickJS) settleLater(id int32, result func() quickjs.Value, err error) {
+	_ = e.do(func() error {
+		e.settle(id, result, err)
+		return nil
+	})
+}
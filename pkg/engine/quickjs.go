@@ -1,16 +1,20 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/buke/quickjs-go"
 	"github.com/jialeicui/golibevdev"
 	"github.com/samber/lo"
 
 	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/store"
 	"github.com/jialeicui/keyswift/pkg/utils/cache"
 )
 
@@ -18,16 +22,115 @@ var _ Engine = (*QuickJS)(nil)
 
 const maxPressed = 16
 
-type QuickJS struct {
-	rt quickjs.Runtime
+var ErrReleased = errors.New("engine released")
+
+// defaultScriptPath is the file name of a script without a path, the relative imports are resolved against the working directory
+const defaultScriptPath = "config.js"
+
+// binding is a callback registered by the script
+type binding struct {
+	fn quickjs.Value
+	// repeat asks for the key repeat events as well
+	repeat bool
+	// when are the scopes of KeySwift.when the binding is registered in, the active window must match all of them
+	when []windowMatch
+	// devices are the devices the keys are pressed on
+	devices DeviceFilter
+	// sides are the modifiers bound to a side, like "r-ctrl", they must be pressed as is
+	sides []keys.Key
+	// order is the press order of the keys asked by the ordered option, nil if any order matches
+	order []keys.Key
+}
 
-	byteCode []byte
-	script   string
+// match returns true if the pressed keys in press order fulfill the side and order constraints of the binding
+func (b binding) match(pressed []keys.Key) bool {
+	if !lo.Every(pressed, b.sides) {
+		return false
+	}
+	return b.order == nil || slices.EqualFunc(b.order, pressed, func(a, b keys.Key) bool {
+		return keys.Normalize(a) == keys.Normalize(b)
+	})
+}
 
-	init      bool
-	keysWatch map[[maxPressed]golibevdev.KeyEventCode]struct{}
+// QuickJS keeps a single long-lived QuickJS context.
+// The script is evaluated once, callbacks registered by it are kept
+// and invoked directly when a matching event arrives.
+// QuickJS runtimes are bound to the OS thread that created them,
+// so all script execution happens on one dedicated goroutine.
+type QuickJS struct {
+	rt  quickjs.Runtime
+	ctx *quickjs.Context
+
+	tasks chan func()
+	quit  chan struct{}
+	done  chan struct{}
+	// releaseOnce closes quit
+	releaseOnce sync.Once
+
+	// scriptPath is the file name of the script, the relative imports are resolved against it
+	scriptPath string
+
+	// session is the bus of the event being dispatched, nil outside of callbacks
+	session Bus
+	// retainFn returns its argument, calling it gives us an owned reference of a value
+	retainFn quickjs.Value
+
+	keysWatch    map[[maxPressed]golibevdev.KeyEventCode][]binding
+	releaseWatch map[[maxPressed]golibevdev.KeyEventCode][]binding
+
+	// mu guards the bindings read by the handler goroutines
+	mu        sync.RWMutex
+	tapHolds  map[keys.Key]TapHold
+	combos    []Combo
+	layers    map[string]Layer
+	layerKeys map[keys.Key]LayerKey
+	sticky    map[keys.Key]struct{}
+	// modifierTaps are the modifiers bound by onModifierTap, suppressedTaps are the ones whose taps are dropped
+	modifierTaps   map[keys.Key]ModifierTap
+	suppressedTaps map[keys.Key]struct{}
+	// modifierPolicies are set by setModifierPolicy, classModifierPolicies override them in the windows
+	modifierPolicies      map[keys.Key]ModifierPolicy
+	classModifierPolicies map[string]map[keys.Key]ModifierPolicy
+	hotstrings            []Hotstring
+
+	unicodeMethod  UnicodeMethod
+	unicodeMethods map[string]UnicodeMethod
+	layoutName     string
+	layoutVariant  string
+	keyRepeat      KeyRepeat
+
+	// scope is the stack of KeySwift.when being evaluated
+	scope []windowMatch
+	// focusBindings are the callbacks of onWindowFocus
+	focusBindings []quickjs.Value
+	// modifierTapBindings are the callbacks of the modifier taps indexed by ModifierTap.ID
+	modifierTapBindings []binding
+	// comboBindings are the callbacks of the combos indexed by Combo.ID
+	comboBindings []binding
+
+	// sequences is the prefix trie of the key sequences,
+	// pendingSequences are the prefixes matched by the devices, the keyboards type their sequences apart
+	sequences        *sequenceNode
+	pendingSequences map[*Device]*sequenceNode
+	leader           sequenceStep
 
 	keyCache cache.Cache[string, []keys.Key]
+
+	// timers are the pending timers of setTimeout and setInterval, they're only accessed on the js goroutine
+	timers      map[int32]*timer
+	nextTimerID int32
+	// busFactory creates the bus of the timer callbacks
+	busFactory func() Bus
+	// store is opened on the first use, it's only accessed on the js goroutine
+	store *store.Store
+	// promises are the pending promises of KeySwift.run, they're only accessed on the js goroutine
+	promises      map[int32]quickjs.Value
+	nextPromiseID int32
+
+	// dbus is the session bus, nil if it's not connected
+	dbus DBus
+	// signals are the callbacks of the D-Bus signals, they're guarded by mu
+	signals []signalBinding
 }
 
 func newJsRuntime() quickjs.Runtime {
@@ -37,75 +140,298 @@ func newJsRuntime() quickjs.Runtime {
 		quickjs.WithGCThreshold(2560*1024),
 		quickjs.WithMaxStackSize(65534),
 		quickjs.WithCanBlock(true),
+		quickjs.WithModuleImport(true),
 	)
 }
 
-func NewQuickJS(script string) (*QuickJS, error) {
-	rt := newJsRuntime()
-
-	defer rt.Close()
-
-	ctx := rt.NewContext()
-	defer ctx.Close()
+// Option configures the QuickJS engine
+type Option func(*QuickJS)
 
-	buf, err := ctx.Compile(script)
-	if err != nil {
-		return nil, err
+// WithScriptPath sets the path of the script, the relative imports of its modules are resolved against it
+func WithScriptPath(path string) Option {
+	return func(e *QuickJS) {
+		e.scriptPath = path
 	}
+}
 
+func NewQuickJS(script string, opts ...Option) (*QuickJS, error) {
 	e := &QuickJS{
-		rt:       rt,
-		byteCode: buf,
-		script:   script,
+		tasks: make(chan func()),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+
+		keysWatch:    map[[maxPressed]golibevdev.KeyEventCode][]binding{},
+		releaseWatch: map[[maxPressed]golibevdev.KeyEventCode][]binding{},
+		tapHolds:     map[keys.Key]TapHold{},
+		layers:       map[string]Layer{},
+		layerKeys:    map[keys.Key]LayerKey{},
+		modifierTaps: map[keys.Key]ModifierTap{},
+
+		modifierPolicies:      map[keys.Key]ModifierPolicy{},
+		classModifierPolicies: map[string]map[keys.Key]ModifierPolicy{},
+
+		unicodeMethod:  UnicodeCtrlShiftU,
+		unicodeMethods: map[string]UnicodeMethod{},
+		keyRepeat: KeyRepeat{
+			Enabled:  true,
+			Delay:    DefaultKeyRepeatDelay,
+			Interval: DefaultKeyRepeatInterval,
+		},
+		sequences:        newSequenceNode(nil),
+		pendingSequences: map[*Device]*sequenceNode{},
+		keyCache:         cache.New[string, []keys.Key](),
+		timers:           map[int32]*timer{},
+		promises:         map[int32]quickjs.Value{},
+
+		scriptPath: defaultScriptPath,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
 
-		init:      false,
-		keysWatch: map[[maxPressed]golibevdev.KeyEventCode]struct{}{},
-		keyCache:  cache.New[string, []keys.Key](),
+	ready := make(chan error)
+	go e.loop(script, ready)
+	if err := <-ready; err != nil {
+		return nil, err
 	}
 
 	return e, nil
 }
 
-func (e *QuickJS) Run(session Bus) error {
-	if e.fastIgnore(session) {
-		return nil
+// loop owns the js runtime, it evaluates the script and then runs tasks until released
+func (e *QuickJS) loop(script string, ready chan<- error) {
+	defer close(e.done)
+
+	e.rt = newJsRuntime()
+	defer e.rt.Close()
+
+	e.ctx = e.rt.NewContext()
+	defer e.ctx.Close()
+	defer e.freeValues()
+	defer e.flushStore()
+
+	if err := e.setup(script); err != nil {
+		ready <- err
+		return
+	}
+	close(ready)
+
+	for {
+		select {
+		case task := <-e.tasks:
+			task()
+			e.runJobs()
+		case <-e.quit:
+			return
+		}
 	}
+}
+
+// freeValues frees the js values kept by the engine
+func (e *QuickJS) freeValues() {
+	for _, watch := range []map[[maxPressed]golibevdev.KeyEventCode][]binding{e.keysWatch, e.releaseWatch} {
+		for _, bindings := range watch {
+			for _, b := range bindings {
+				b.fn.Free()
+			}
+		}
+	}
+	for _, b := range e.comboBindings {
+		b.fn.Free()
+	}
+	for _, fn := range e.focusBindings {
+		fn.Free()
+	}
+	for _, b := range e.modifierTapBindings {
+		b.fn.Free()
+	}
+	e.sequences.free()
+	for _, t := range e.timers {
+		t.free()
+	}
+	for _, p := range e.promises {
+		p.Free()
+	}
+	for _, b := range e.signals {
+		b.fn.Free()
+	}
+	e.retainFn.Free()
+}
 
-	rt := newJsRuntime()
-	defer rt.Close()
+func (e *QuickJS) setup(script string) error {
+	retainFn, err := e.ctx.Eval("(v) => v")
+	if err != nil {
+		return err
+	}
+	e.retainFn = retainFn
+	if err := checkModules(e.scriptPath, script); err != nil {
+		return err
+	}
 
-	ctx := rt.NewContext()
-	defer ctx.Close()
+	e.registerConsole(e.ctx)
+	e.registerTimers(e.ctx)
+	e.registerKeySwift(e.ctx)
 
-	e.registerConsole(ctx)
-	e.registerKeySwift(ctx, session)
+	// the script is evaluated as a module if it imports or exports
+	ret, err := e.ctx.Eval(script, quickjs.EvalFileName(e.scriptPath))
+	if err != nil {
+		return fmt.Errorf("failed to evaluate %s: %w", e.scriptPath, err)
+	}
+	defer ret.Free()
+	if !ret.IsPromise() {
+		e.runJobs()
+		return nil
+	}
 
-	ret, err := ctx.EvalBytecode(e.byteCode)
+	// the evaluation of a module is a promise, its rejection is the error thrown by the modules.
+	// It can't be awaited here, the promises of KeySwift are settled by the tasks of the loop
+	watch, err := e.ctx.Eval(`(p) => { const o = {}; p.catch((err) => { o.error = err }); return o; }`)
 	if err != nil {
 		return err
 	}
-	ret.Free()
-	e.init = true
+	defer watch.Free()
+	outcome := e.ctx.Invoke(watch, e.ctx.Undefined(), ret)
+	defer outcome.Free()
+	e.runJobs()
+	if outcome.Has("error") {
+		errValue := outcome.Get("error")
+		defer errValue.Free()
+		return fmt.Errorf("failed to evaluate %s: %s", e.scriptPath, errValue.String())
+	}
 	return nil
 }
 
-func (e *QuickJS) Release() {
-	e.rt.Close()
+// do runs fn on the js goroutine and waits for it to finish
+func (e *QuickJS) do(fn func() error) (err error) {
+	finished := make(chan struct{})
+	task := func() {
+		defer close(finished)
+		err = fn()
+	}
+
+	select {
+	case e.tasks <- task:
+	case <-e.done:
+		return ErrReleased
+	}
+	<-finished
+	return err
 }
 
-func (e *QuickJS) fastIgnore(session Bus) bool {
-	if !e.init {
-		return false
+func (e *QuickJS) Run(session Bus) error {
+	return e.do(func() error {
+		if session.GetKeyEventType() == KeyEventPress {
+			if consumed, err := e.runSequence(session); consumed {
+				return err
+			}
+		}
+		return e.runBindings(session, e.matchBindings(session))
+	})
+}
+
+// runBindings invokes the callbacks of the bindings with the key event of the session
+func (e *QuickJS) runBindings(session Bus, bindings []binding) error {
+	bindings = e.scoped(session, bindings)
+	if len(bindings) == 0 {
+		return nil
 	}
 
-	pressed := session.GetPressedKeys()
-	slices.Sort(pressed)
+	e.session = session
+	defer func() {
+		e.session = nil
+	}()
+
+	event := e.newKeyEvent(session)
+	defer event.Free()
+
+	var errs []error
+	for _, b := range bindings {
+		if err := e.invoke(b.fn, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *QuickJS) Release() {
+	e.releaseOnce.Do(func() {
+		close(e.quit)
+	})
+	<-e.done
+}
+
+// chordKey returns the lookup key of a chord, the order of the keys doesn't matter
+func chordKey(codes []keys.Key) [maxPressed]golibevdev.KeyEventCode {
+	sorted := slices.Clone(codes)
+	slices.Sort(sorted)
 
 	k := [maxPressed]golibevdev.KeyEventCode{}
-	copy(k[:], pressed)
-	_, ok := e.keysWatch[k]
-	slog.Debug("fastIgnore", "keys", pressed, "ok", !ok)
-	return !ok
+	copy(k[:], sorted)
+	return k
+}
+
+// modifierChordKey returns the lookup key of a chord of the key bindings, the modifiers of either side are the same
+func modifierChordKey(codes []keys.Key) [maxPressed]golibevdev.KeyEventCode {
+	return chordKey(lo.Map(codes, func(code keys.Key, _ int) keys.Key {
+		return keys.Normalize(code)
+	}))
+}
+
+func (e *QuickJS) matchBindings(session Bus) []binding {
+	pressed := session.GetPressedKeys()
+	k := modifierChordKey(pressed)
+
+	eventType := session.GetKeyEventType()
+	var bindings []binding
+	switch eventType {
+	case KeyEventRelease:
+		bindings = e.releaseWatch[k]
+	case KeyEventRepeat:
+		bindings = lo.Filter(e.keysWatch[k], func(b binding, _ int) bool {
+			return b.repeat
+		})
+	default:
+		bindings = e.keysWatch[k]
+	}
+	if slices.ContainsFunc(bindings, func(b binding) bool { return len(b.sides) > 0 || b.order != nil }) {
+		bindings = lo.Filter(bindings, func(b binding, _ int) bool {
+			return b.match(pressed)
+		})
+	}
+	slog.Debug("matchBindings", "keys", pressed, "type", eventType, "bindings", len(bindings))
+	return bindings
+}
+
+// newKeyEvent creates the event object passed to the key callbacks
+func (e *QuickJS) newKeyEvent(session Bus) quickjs.Value {
+	eventType := session.GetKeyEventType()
+	event := e.ctx.Object()
+	event.Set("type", e.ctx.String(eventType.String()))
+	event.Set("pressed", e.ctx.Bool(eventType != KeyEventRelease))
+	event.Set("repeat", e.ctx.Bool(eventType == KeyEventRepeat))
+	event.Set("time", e.ctx.Float64(float64(session.GetEventTime().UnixMicro())/1000))
+
+	names := e.ctx.Array().ToValue()
+	for i, key := range session.GetPressedKeys() {
+		names.SetIdx(int64(i), e.ctx.String(keys.Name(key)))
+	}
+	event.Set("keys", names)
+	return event
+}
+
+// invoke calls a js function and reports the exception it throws
+func (e *QuickJS) invoke(fn quickjs.Value, args ...quickjs.Value) error {
+	ret := e.ctx.Invoke(fn, e.ctx.Undefined(), args...)
+	if ret.IsException() {
+		return e.ctx.Exception()
+	}
+	ret.Free()
+	return nil
+}
+
+// retain returns an owned reference of v which stays valid after the current call returns
+func (e *QuickJS) retain(v quickjs.Value) quickjs.Value {
+	return e.ctx.Invoke(e.retainFn, e.ctx.Undefined(), v)
 }
 
 func (e *QuickJS) registerConsole(ctx *quickjs.Context) {
@@ -119,12 +445,16 @@ func (e *QuickJS) registerConsole(ctx *quickjs.Context) {
 	ctx.Globals().Set("console", console)
 }
 
-func (e *QuickJS) registerKeySwift(ctx *quickjs.Context, session Bus) {
+func (e *QuickJS) registerKeySwift(ctx *quickjs.Context) {
 	keySwift := ctx.Object()
 	ctx.Globals().Set(KeySwiftObj, keySwift)
 
 	keySwift.Set(FuncGetActiveWindowClass, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
-		return ctx.String(session.GetActiveWindowClass())
+		if e.session == nil {
+			slog.Warn("getActiveWindowClass should be called inside a callback")
+			return ctx.String("")
+		}
+		return ctx.String(e.session.GetActiveWindowClass())
 	}))
 
 	keySwift.Set(FuncSendKeys, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
@@ -136,34 +466,50 @@ func (e *QuickJS) registerKeySwift(ctx *quickjs.Context, session Bus) {
 			return ctx.Undefined()
 		}
 
-		jsKeys := args[0].ToArray()
-		keyStrArr := make([]string, 0, jsKeys.Len())
-		for i := int64(0); i < jsKeys.Len(); i++ {
-			item, err := jsKeys.Get(i)
-			if err != nil {
-				return ctx.Undefined()
-			}
-			keyStrArr = append(keyStrArr, item.String())
+		if e.session == nil {
+			slog.Error("sendKeys should be called inside a callback")
+			return ctx.Undefined()
 		}
-		slices.Sort(keyStrArr)
-
-		keyCodes, err := e.keyCache.Get(strings.Join(keyStrArr, ","), func() ([]keys.Key, error) {
-			return keys.GetKeyCodes(keyStrArr)
-		})
 
+		keyCodes, err := e.getKeyCodes(args[0])
 		if err != nil {
 			slog.Error("failed to get key codes", "error", err)
 			return ctx.Undefined()
 		}
 
-		session.SendKeys(keyCodes)
+		e.session.SendKeys(keyCodes)
 
 		return ctx.Undefined()
 	}))
 
-	keySwift.Set(FuncOnKeyPress, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
-		if len(args) != 2 {
-			slog.Error("OnKeyPress requires two arguments")
+	keySwift.Set(FuncOnKeyPress, ctx.Function(e.onKeys(FuncOnKeyPress, e.keysWatch)))
+	keySwift.Set(FuncOnKeyRelease, ctx.Function(e.onKeys(FuncOnKeyRelease, e.releaseWatch)))
+
+	e.registerTapHold(ctx, keySwift)
+	e.registerSequence(ctx, keySwift)
+	e.registerCombo(ctx, keySwift)
+	e.registerLayer(ctx, keySwift)
+	e.registerSticky(ctx, keySwift)
+	e.registerModifierTap(ctx, keySwift)
+	e.registerModifierPolicy(ctx, keySwift)
+	e.registerHotstring(ctx, keySwift)
+	e.registerUnicode(ctx, keySwift)
+	e.registerMacro(ctx, keySwift)
+	e.registerRecorder(ctx, keySwift)
+	e.registerKeyRepeat(ctx, keySwift)
+	e.registerStore(ctx, keySwift)
+	e.registerExec(ctx, keySwift)
+	e.registerDBus(ctx, keySwift)
+	e.registerWindow(ctx, keySwift)
+	e.registerDevice(ctx, keySwift)
+}
+
+// onKeys returns the js function registering callbacks into watch,
+// it's called as name(keys, callback, {repeat, ordered, devices})
+func (e *QuickJS) onKeys(name string, watch map[[maxPressed]golibevdev.KeyEventCode][]binding) func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+	return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error(name + " requires two or three arguments")
 			return ctx.Undefined()
 		}
 
@@ -173,50 +519,123 @@ func (e *QuickJS) registerKeySwift(ctx *quickjs.Context, session Bus) {
 		}
 
 		if !args[1].IsFunction() {
-			slog.Error("OnKeyPress requires a function as the second argument")
+			slog.Error(name + " requires a function as the second argument")
 			return ctx.Undefined()
 		}
 
-		jsKeys := args[0].ToArray()
+		names, err := getStrings(args[0])
+		if err != nil {
+			slog.Error("failed to get key names", "error", err)
+			return ctx.Undefined()
+		}
+		expected, err := e.keyCodes(names)
+		if err != nil {
+			slog.Error("failed to get key codes", "error", err)
+			return ctx.Undefined()
+		}
 
-		keyStrArr := make([]string, 0, jsKeys.Len())
-		for i := int64(0); i < jsKeys.Len(); i++ {
-			item, err := jsKeys.Get(i)
-			if err != nil {
-				slog.Error("failed to get key by index", "error", err, "index", i)
-				return ctx.Undefined()
+		b := e.newBinding(args[1])
+		b.sides = e.sidedModifiers(names)
+		if len(args) == 3 && args[2].IsObject() {
+			b.repeat = optionBool(args[2], "repeat")
+			if optionBool(args[2], "ordered") {
+				// the codes of keyCodes are sorted by name
+				b.order, _ = keys.GetKeyCodes(names)
 			}
-			if !item.IsString() {
-				slog.Error("key is not a string", "key", item.String())
+			if b.devices, err = optionDevices(args[2]); err != nil {
+				b.fn.Free()
+				slog.Error("failed to parse devices", "error", err)
 				return ctx.Undefined()
 			}
-			// TODO: if modifier key position is not fixed, we need to handle it
-			keyStrArr = append(keyStrArr, item.String())
 		}
 
-		slices.Sort(keyStrArr)
-		expected, err := e.keyCache.Get(strings.Join(keyStrArr, ","), func() ([]keys.Key, error) {
-			return keys.GetKeyCodes(keyStrArr)
-		})
-		if err != nil {
-			slog.Error("failed to get key codes", "error", err)
-			return ctx.Undefined()
-		}
+		slog.Debug("add keys watch", "func", name, "codes", expected)
+		k := modifierChordKey(expected)
+		watch[k] = append(watch[k], b)
+
+		return ctx.Undefined()
+	}
+}
+
+// getKeyCodes converts a js array of key names to key codes
+func (e *QuickJS) getKeyCodes(v quickjs.Value) ([]keys.Key, error) {
+	names, err := getStrings(v)
+	if err != nil {
+		return nil, err
+	}
+	return e.keyCodes(names)
+}
+
+// keyCodes converts key names to key codes
+func (e *QuickJS) keyCodes(names []string) ([]keys.Key, error) {
+	keyStrArr := slices.Clone(names)
+	slices.Sort(keyStrArr)
+
+	return e.keyCache.Get(strings.Join(keyStrArr, ","), func() ([]keys.Key, error) {
+		return keys.GetKeyCodes(keyStrArr)
+	})
+}
 
-		if !e.init {
-			slices.Sort(expected)
-			slog.Debug("add keys watch", "keys", keyStrArr, "codes", expected)
-			k := [maxPressed]golibevdev.KeyEventCode{}
-			copy(k[:], expected)
-			e.keysWatch[k] = struct{}{}
+// sidedModifiers returns the modifiers of the valid names bound to a side, like "r-ctrl" or "leftctrl"
+func (e *QuickJS) sidedModifiers(names []string) []keys.Key {
+	var sides []keys.Key
+	for _, name := range names {
+		if keys.IsGeneric(name) {
+			continue
 		}
+		if codes, err := e.keyCodes([]string{name}); err == nil && keys.IsModifier(codes[0]) {
+			sides = append(sides, codes[0])
+		}
+	}
+	return sides
+}
 
-		curKeys := session.GetPressedKeys()
+// getStrings converts a js array of strings
+func getStrings(v quickjs.Value) ([]string, error) {
+	if !v.IsArray() {
+		return nil, fmt.Errorf("keys must be an array")
+	}
 
-		if a, b := lo.Difference(curKeys, expected); len(a) == 0 && len(b) == 0 {
-			ctx.Invoke(args[1], this)
+	jsKeys := v.ToArray()
+	ret := make([]string, 0, jsKeys.Len())
+	for i := int64(0); i < jsKeys.Len(); i++ {
+		item, err := jsKeys.Get(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key by index %d: %w", i, err)
+		}
+		str, isString := item.String(), item.IsString()
+		item.Free()
+		if !isString {
+			return nil, fmt.Errorf("key is not a string: %s", str)
 		}
+		ret = append(ret, str)
+	}
+	return ret, nil
+}
 
-		return ctx.Undefined()
-	}))
+// optionKeyCodes returns the key codes of an array property of a js options object
+func (e *QuickJS) optionKeyCodes(opts quickjs.Value, name string) ([]keys.Key, error) {
+	v := opts.Get(name)
+	defer v.Free()
+	if v.IsUndefined() {
+		return nil, nil
+	}
+	return e.getKeyCodes(v)
+}
+
+// optionBool returns a boolean property of a js options object
+func optionBool(opts quickjs.Value, name string) bool {
+	v := opts.Get(name)
+	defer v.Free()
+	return v.Bool()
+}
+
+// optionDuration returns a property of a js options object in milliseconds as duration
+func optionDuration(opts quickjs.Value, name string, def time.Duration) time.Duration {
+	v := opts.Get(name)
+	defer v.Free()
+	if !v.IsNumber() {
+		return def
+	}
+	return time.Duration(v.Float64() * float64(time.Millisecond))
 }
@@ -0,0 +1,1089 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+type fakeBus struct {
+	windowClass string
+	windowTitle string
+	device      *Device
+	pressed     []keys.Key
+	eventType   KeyEventType
+	eventTime   time.Time
+	sent        [][]keys.Key
+	typed       string
+	// pending is the timeout of the pending key sequence, zero if none
+	pending   time.Duration
+	completed bool
+	aborted   bool
+	layers    []string
+	macros    [][]MacroStep
+	keyDelay  time.Duration
+	// recording is the register being recorded
+	recording string
+	persist   bool
+	replayed  []string
+	replay    ReplayOptions
+}
+
+func (b *fakeBus) GetActiveWindowClass() string {
+	return b.windowClass
+}
+
+func (b *fakeBus) GetActiveWindow() *Window {
+	return &Window{Title: b.windowTitle, Class: b.windowClass}
+}
+
+func (b *fakeBus) GetDevice() *Device {
+	return b.device
+}
+
+func (b *fakeBus) GetPressedKeys() []keys.Key {
+	return b.pressed
+}
+
+func (b *fakeBus) GetKeyEventType() KeyEventType {
+	return b.eventType
+}
+
+func (b *fakeBus) GetEventTime() time.Time {
+	return b.eventTime
+}
+
+func (b *fakeBus) SendKeys(codes []keys.Key) {
+	b.sent = append(b.sent, codes)
+}
+
+func (b *fakeBus) TypeText(text string) {
+	b.typed += text
+}
+
+func (b *fakeBus) TypeUnicode(text string) {
+	b.typed += text
+}
+
+func (b *fakeBus) RunMacro(steps []MacroStep, keyDelay time.Duration) {
+	b.macros = append(b.macros, steps)
+	b.keyDelay = keyDelay
+}
+
+func (b *fakeBus) StartRecording(register string, persist bool) error {
+	b.recording = register
+	b.persist = persist
+	return nil
+}
+
+func (b *fakeBus) StopRecording() error {
+	b.recording = ""
+	return nil
+}
+
+func (b *fakeBus) IsRecording() bool {
+	return b.recording != ""
+}
+
+func (b *fakeBus) Replay(register string, opts ReplayOptions) error {
+	b.replayed = append(b.replayed, register)
+	b.replay = opts
+	return nil
+}
+
+func (b *fakeBus) PendSequence(timeout time.Duration) {
+	b.pending = timeout
+}
+
+func (b *fakeBus) CompleteSequence() {
+	b.completed = true
+}
+
+func (b *fakeBus) AbortSequence() {
+	b.aborted = true
+}
+
+func (b *fakeBus) GetActiveLayers() []string {
+	return b.layers
+}
+
+func (b *fakeBus) ActivateLayer(name string, _ LayerMode) {
+	b.layers = append(b.layers, name)
+}
+
+func (b *fakeBus) DeactivateLayer(name string) {
+	b.layers = slices.DeleteFunc(b.layers, func(s string) bool {
+		return s == name
+	})
+}
+
+func mustKeys(t *testing.T, names ...string) []keys.Key {
+	codes, err := keys.GetKeyCodes(names)
+	require.NoError(t, err)
+	return codes
+}
+
+func newTestEngine(t *testing.T, script string) *QuickJS {
+	e, err := NewQuickJS(script)
+	require.NoError(t, err)
+	t.Cleanup(e.Release)
+	return e
+}
+
+func TestQuickJSKeepsState(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+let count = 0;
+KeySwift.onKeyPress(["ctrl", "a"], () => {
+    count++;
+    if (count % 2 === 0) {
+        KeySwift.sendKeys(["home"]);
+    }
+});
+`)
+
+	for i := 0; i < 4; i++ {
+		must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "a", "ctrl")}))
+	}
+
+	b := &fakeBus{pressed: mustKeys(t, "ctrl", "a")}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "ctrl", "a")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "home")}, b.sent)
+}
+
+func TestQuickJSWindowClassInCallback(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["cmd", "c"], () => {
+    if (KeySwift.getActiveWindowClass() === "kitty") {
+        KeySwift.sendKeys(["ctrl", "shift", "c"]);
+    }
+});
+`)
+
+	b := &fakeBus{windowClass: "firefox", pressed: mustKeys(t, "cmd", "c")}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+
+	b = &fakeBus{windowClass: "kitty", pressed: mustKeys(t, "cmd", "c")}
+	must.NoError(e.Run(b))
+	must.Len(b.sent, 1)
+
+	b = &fakeBus{windowClass: "kitty", pressed: mustKeys(t, "cmd", "v")}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+}
+
+func TestQuickJSKeyEvents(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["ctrl", "space"], (e) => {
+    if (e.type === "press" && !e.repeat && e.time === 1500 && e.keys.length === 2) {
+        KeySwift.sendKeys(["f1"]);
+    }
+});
+KeySwift.onKeyPress(["f13"], (e) => {
+    KeySwift.sendKeys([e.repeat ? "f2" : "f3"]);
+}, {repeat: true});
+KeySwift.onKeyRelease(["ctrl", "space"], (e) => {
+    if (e.type === "release" && !e.pressed) {
+        KeySwift.sendKeys(["f4"]);
+    }
+});
+`)
+
+	b := &fakeBus{pressed: mustKeys(t, "space", "ctrl"), eventTime: time.UnixMilli(1500)}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f1")}, b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "space", "ctrl"), eventType: KeyEventRepeat}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "f13"), eventType: KeyEventRepeat}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f2")}, b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "space", "ctrl"), eventType: KeyEventRelease}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f4")}, b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "f13"), eventType: KeyEventRelease}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+}
+
+func TestQuickJSSequence(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.setLeader(["space"]);
+KeySwift.onSequence(["ctrl+x", "ctrl+s"], () => KeySwift.sendKeys(["f1"]), {timeoutMs: 500});
+KeySwift.onSequence(["leader", "g", "g"], () => KeySwift.sendKeys(["home"]));
+KeySwift.onSequence(["leader", "g"], () => KeySwift.sendKeys(["f2"]));
+`)
+
+	b := &fakeBus{pressed: mustKeys(t, "ctrl", "x")}
+	must.NoError(e.Run(b))
+	must.Equal(500*time.Millisecond, b.pending)
+
+	// modifiers alone keep the sequence pending
+	b = &fakeBus{pressed: mustKeys(t, "ctrl")}
+	must.NoError(e.Run(b))
+	must.False(b.aborted)
+
+	b = &fakeBus{pressed: mustKeys(t, "ctrl", "s")}
+	must.NoError(e.Run(b))
+	must.True(b.completed)
+	must.Equal([][]keys.Key{mustKeys(t, "f1")}, b.sent)
+
+	// a key outside of the sequence aborts it
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "ctrl", "x")}))
+	b = &fakeBus{pressed: mustKeys(t, "a")}
+	must.NoError(e.Run(b))
+	must.True(b.aborted)
+	must.Empty(b.sent)
+
+	// the prefix binding fires when the sequence times out
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "space")}))
+	b = &fakeBus{pressed: mustKeys(t, "g")}
+	must.NoError(e.Run(b))
+	must.Equal(DefaultSequenceTimeout, b.pending)
+	b = &fakeBus{}
+	must.NoError(e.ExpireSequence(b))
+	must.True(b.completed)
+	must.Equal([][]keys.Key{mustKeys(t, "f2")}, b.sent)
+}
+
+func TestQuickJSCombo(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.onCombo(["j", "k"], (e) => KeySwift.sendKeys(["esc"]));
+KeySwift.onCombo(["ctrl", "k"], () => {});
+KeySwift.onCombo(["s", "d"], () => {}, {timeoutMs: 50});
+`)
+
+	combos := e.Combos()
+	must.Len(combos, 2)
+	must.ElementsMatch(mustKeys(t, "j", "k"), combos[0].Keys)
+	must.Equal(DefaultComboTimeout, combos[0].Timeout)
+	must.Equal(50*time.Millisecond, combos[1].Timeout)
+
+	b := &fakeBus{pressed: combos[0].Keys}
+	must.NoError(e.RunCombo(b, combos[0].ID))
+	must.Equal([][]keys.Key{mustKeys(t, "esc")}, b.sent)
+	must.Error(e.RunCombo(b, 5))
+}
+
+func TestQuickJSLayer(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.layer("nav", {h: "left", w: "ctrl+right", e: ["shift", "end"]}, {hold: "capslock", toggle: "f12"});
+KeySwift.onKeyPress(["f1"], () => KeySwift.toggleLayer("num"));
+KeySwift.onKeyPress(["f2"], () => {
+    if (KeySwift.getActiveLayers().includes("num")) {
+        KeySwift.sendKeys(["f3"]);
+    }
+});
+`)
+
+	l, ok := e.Layer("nav")
+	must.True(ok)
+	must.Equal(mustKeys(t, "left"), l.Keys[mustKeys(t, "h")[0]])
+	must.ElementsMatch(mustKeys(t, "ctrl", "right"), l.Keys[mustKeys(t, "w")[0]])
+	must.ElementsMatch(mustKeys(t, "shift", "end"), l.Keys[mustKeys(t, "e")[0]])
+
+	lk, ok := e.LayerKey(mustKeys(t, "capslock")[0])
+	must.True(ok)
+	must.Equal(LayerKey{Key: mustKeys(t, "capslock")[0], Layer: "nav", Mode: LayerMomentary}, lk)
+	lk, ok = e.LayerKey(mustKeys(t, "f12")[0])
+	must.True(ok)
+	must.Equal(LayerToggle, lk.Mode)
+
+	b := &fakeBus{pressed: mustKeys(t, "f1")}
+	must.NoError(e.Run(b))
+	must.Equal([]string{"num"}, b.layers)
+
+	b.pressed = mustKeys(t, "f2")
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f3")}, b.sent)
+}
+
+func TestQuickJSStickyModifiers(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.setStickyModifiers(["shift", "ctrl"]);
+KeySwift.setStickyModifiers(["alt", "a"]);
+`)
+
+	for _, name := range []string{"leftshift", "rightshift", "leftctrl", "rightctrl"} {
+		must.True(e.IsStickyModifier(mustKeys(t, name)[0]), name)
+	}
+	must.False(e.IsStickyModifier(mustKeys(t, "alt")[0]))
+
+	e = newTestEngine(t, `KeySwift.setStickyModifiers(["r-alt"]);`)
+	must.True(e.IsStickyModifier(mustKeys(t, "rightalt")[0]))
+	must.False(e.IsStickyModifier(mustKeys(t, "leftalt")[0]))
+}
+
+func TestQuickJSHotstring(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.hotstring(";sig", "Best regards,\nJialei", {immediate: true});
+KeySwift.hotstring("btw", "by the way", {windowClass: ["kitty", "firefox"]});
+KeySwift.hotstring("", "empty");
+`)
+
+	must.Equal([]Hotstring{
+		{Trigger: ";sig", Replacement: "Best regards,\nJialei", Immediate: true},
+		{Trigger: "btw", Replacement: "by the way", WindowClasses: []string{"kitty", "firefox"}},
+	}, e.Hotstrings())
+	must.True(e.Hotstrings()[0].Match("kitty"))
+	must.False(e.Hotstrings()[1].Match("code"))
+}
+
+func TestQuickJSUnicode(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.setUnicodeMethod("ctrl-shift-u-enter", {windowClass: ["kitty"]});
+KeySwift.setUnicodeMethod("alt-x");
+KeySwift.setLayout("de", "nodeadkeys");
+KeySwift.onKeyPress(["alt", "minus"], () => KeySwift.typeUnicode("—"));
+KeySwift.onKeyPress(["alt", "h"], () => KeySwift.typeText("Hello, World!"));
+`)
+
+	must.Equal(UnicodeCtrlShiftU, e.UnicodeMethod("firefox"))
+	must.Equal(UnicodeCtrlShiftUEnter, e.UnicodeMethod("kitty"))
+	name, variant := e.KeyboardLayout()
+	must.Equal("de", name)
+	must.Equal("nodeadkeys", variant)
+
+	b := &fakeBus{pressed: mustKeys(t, "alt", "minus")}
+	must.NoError(e.Run(b))
+	must.Equal("—", b.typed)
+
+	b = &fakeBus{pressed: mustKeys(t, "alt", "h")}
+	must.NoError(e.Run(b))
+	must.Equal("Hello, World!", b.typed)
+}
+
+func TestQuickJSMacro(t *testing.T) {
+	must := require.New(t)
+
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["f5"], () => KeySwift.macro([
+    {press: "ctrl"},
+    {tap: ["k", "b"]},
+    {release: "ctrl"},
+    {delay: 50},
+    {tap: "shift+end"},
+    {text: "done"},
+    {unicode: "→"},
+], {keyDelayMs: 20}));
+KeySwift.onKeyPress(["f6"], () => KeySwift.macro([{tap: "a", delay: 1}]));
+KeySwift.onKeyPress(["f7"], () => KeySwift.macro([{type: "a"}]));
+`)
+
+	b := &fakeBus{pressed: mustKeys(t, "f5")}
+	must.NoError(e.Run(b))
+	must.Equal([][]MacroStep{{
+		{Action: MacroPress, Keys: mustKeys(t, "ctrl")},
+		{Action: MacroTap, Keys: mustKeys(t, "k", "b")},
+		{Action: MacroRelease, Keys: mustKeys(t, "ctrl")},
+		{Action: MacroDelay, Delay: 50 * time.Millisecond},
+		{Action: MacroTap, Keys: mustKeys(t, "shift", "end")},
+		{Action: MacroText, Text: "done"},
+		{Action: MacroUnicode, Text: "→"},
+	}}, b.macros)
+	must.Equal(20*time.Millisecond, b.keyDelay)
+
+	for _, key := range []string{"f6", "f7"} {
+		b = &fakeBus{pressed: mustKeys(t, key)}
+		must.NoError(e.Run(b))
+		must.Empty(b.macros)
+	}
+}
+
+func TestQuickJSRecorder(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["f9"], () => {
+    if (KeySwift.isRecording()) {
+        KeySwift.stopRecording();
+    } else {
+        KeySwift.startRecording("a", {persist: true});
+    }
+});
+KeySwift.onKeyPress(["f10"], () => KeySwift.replay("a"));
+KeySwift.onKeyPress(["f11"], () => KeySwift.replay("a", {timing: "compressed", keyDelayMs: 20}));
+KeySwift.onKeyPress(["f12"], () => KeySwift.replay("a", {timing: "slow"}));
+`)
+
+	b := &fakeBus{pressed: mustKeys(t, "f9")}
+	must.NoError(e.Run(b))
+	must.Equal("a", b.recording)
+	must.True(b.persist)
+	must.NoError(e.Run(b))
+	must.Empty(b.recording)
+
+	b = &fakeBus{pressed: mustKeys(t, "f10")}
+	must.NoError(e.Run(b))
+	must.Equal([]string{"a"}, b.replayed)
+	must.Equal(ReplayOptions{OriginalTiming: true, KeyDelay: DefaultMacroKeyDelay}, b.replay)
+
+	b = &fakeBus{pressed: mustKeys(t, "f11")}
+	must.NoError(e.Run(b))
+	must.Equal(ReplayOptions{KeyDelay: 20 * time.Millisecond}, b.replay)
+
+	b = &fakeBus{pressed: mustKeys(t, "f12")}
+	must.NoError(e.Run(b))
+	must.Empty(b.replayed)
+}
+
+func TestQuickJSKeyRepeat(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, ``)
+	must.Equal(KeyRepeat{Enabled: true, Delay: DefaultKeyRepeatDelay, Interval: DefaultKeyRepeatInterval}, e.KeyRepeat())
+
+	e = newTestEngine(t, `KeySwift.setKeyRepeat({delayMs: 300, intervalMs: 20});`)
+	must.Equal(KeyRepeat{Enabled: true, Delay: 300 * time.Millisecond, Interval: 20 * time.Millisecond}, e.KeyRepeat())
+
+	e = newTestEngine(t, `KeySwift.setKeyRepeat(false);`)
+	must.False(e.KeyRepeat().Enabled)
+
+	e = newTestEngine(t, `KeySwift.setKeyRepeat({intervalMs: 0});`)
+	must.Equal(DefaultKeyRepeatInterval, e.KeyRepeat().Interval)
+}
+
+// chanBus sends the keys to a channel, it's used by the callbacks running on the js goroutine in the background
+type chanBus struct {
+	fakeBus
+	keys chan []keys.Key
+}
+
+func (b *chanBus) SendKeys(codes []keys.Key) {
+	b.keys <- codes
+}
+
+// newChanBus makes the callbacks running in the background send the keys to the returned bus
+func newChanBus(e *QuickJS) *chanBus {
+	b := &chanBus{keys: make(chan []keys.Key, 1)}
+	e.SetBusFactory(func() Bus {
+		return b
+	})
+	return b
+}
+
+// expect waits for the key sent in the background
+func (b *chanBus) expect(t *testing.T, key string) {
+	t.Helper()
+	select {
+	case sent := <-b.keys:
+		require.Equal(t, mustKeys(t, key), sent)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout", "waiting for %s", key)
+	}
+}
+
+func TestQuickJSTimers(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+let taps = 0;
+let timer;
+KeySwift.onKeyPress(["f1"], () => {
+    taps++;
+    clearTimeout(timer);
+    timer = setTimeout((n) => {
+        taps = 0;
+        KeySwift.sendKeys([n === 2 ? "f3" : "f2"]);
+    }, 100, taps);
+});
+
+KeySwift.onKeyPress(["f4"], () => {
+    let ticks = 0;
+    const interval = setInterval(() => {
+        if (++ticks === 3) {
+            clearInterval(interval);
+            KeySwift.sendKeys(["f5"]);
+        }
+    }, 1);
+});
+
+KeySwift.onKeyPress(["f6"], async () => {
+    const key = await Promise.resolve("f7");
+    KeySwift.sendKeys([key]);
+});
+`)
+	b := newChanBus(e)
+
+	// a double tap within 100ms
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f1")}))
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f1")}))
+	b.expect(t, "f3")
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f1")}))
+	b.expect(t, "f2")
+
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f4")}))
+	b.expect(t, "f5")
+
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f6")}))
+	b.expect(t, "f7")
+}
+
+func TestQuickJSExec(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["f1"], async () => {
+    const {stdout, code} = await KeySwift.run("echo $KEY; exit 2", {env: {KEY: "f2"}});
+    if (code === 2) {
+        KeySwift.sendKeys([stdout.trim()]);
+    }
+});
+KeySwift.onKeyPress(["f3"], async () => {
+    try {
+        await KeySwift.run(["sleep", "10"], {timeoutMs: 10});
+    } catch (e) {
+        KeySwift.sendKeys(["f4"]);
+    }
+});
+KeySwift.onKeyPress(["f5"], () => {
+    if (KeySwift.exec(["true"], {detach: true}) > 0) {
+        KeySwift.sendKeys(["f6"]);
+    }
+});
+`)
+	b := newChanBus(e)
+
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f1")}))
+	b.expect(t, "f2")
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f3")}))
+	b.expect(t, "f4")
+	b.pressed = mustKeys(t, "f5")
+	must.NoError(e.Run(b))
+	b.expect(t, "f6")
+}
+
+// fakeDBus records the calls and keeps the signal handlers
+type fakeDBus struct {
+	mu       sync.Mutex
+	calls    []string
+	args     [][]any
+	handlers []func(Signal)
+}
+
+func (d *fakeDBus) Call(_ context.Context, dest, path, iface, method, signature string, args []any) ([]any, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, strings.Join([]string{dest, path, iface, method, signature}, " "))
+	d.args = append(d.args, args)
+	if method == "Fail" {
+		return nil, errors.New("no such method")
+	}
+	return []any{map[string]any{"status": "Playing"}}, nil
+}
+
+func (d *fakeDBus) Subscribe(match SignalMatch, handler func(Signal)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if match.Member == "PropertiesChanged" {
+		d.handlers = append(d.handlers, handler)
+	}
+	return nil
+}
+
+func TestQuickJSDBus(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.dbus.onSignal({interface: "org.freedesktop.DBus.Properties", member: "PropertiesChanged"}, (args, signal) => {
+    if (args[0] === "org.mpris.MediaPlayer2.Player" && signal.path === "/org/mpris/MediaPlayer2") {
+        KeySwift.sendKeys(["f1"]);
+    }
+});
+KeySwift.onKeyPress(["f2"], async () => {
+    const reply = await KeySwift.dbus.call("org.mpris.MediaPlayer2.spotify", "/org/mpris/MediaPlayer2",
+        "org.mpris.MediaPlayer2.Player", "Seek", [5000000], {signature: "x"});
+    if (reply.status === "Playing") {
+        KeySwift.sendKeys(["f3"]);
+    }
+});
+KeySwift.onKeyPress(["f4"], () => {
+    KeySwift.dbus.call("a.b", "/", "a.b", "Fail").catch(() => KeySwift.sendKeys(["f5"]));
+});
+`)
+	b := newChanBus(e)
+
+	// not connected yet
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f4")}))
+	b.expect(t, "f5")
+
+	d := &fakeDBus{}
+	e.SetDBus(d)
+	must.Len(d.handlers, 1)
+	d.handlers[0](Signal{
+		Path:      "/org/mpris/MediaPlayer2",
+		Interface: "org.freedesktop.DBus.Properties",
+		Member:    "PropertiesChanged",
+		Body:      []any{"org.mpris.MediaPlayer2.Player", map[string]any{}, []any{}},
+	})
+	b.expect(t, "f1")
+
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f2")}))
+	b.expect(t, "f3")
+	must.Equal("org.mpris.MediaPlayer2.spotify /org/mpris/MediaPlayer2 org.mpris.MediaPlayer2.Player Seek x", d.calls[0])
+	must.Equal([]any{json.Number("5000000")}, d.args[0])
+
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "f4")}))
+	b.expect(t, "f5")
+}
+
+func TestQuickJSStore(t *testing.T) {
+	must := require.New(t)
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	script := `
+KeySwift.onKeyPress(["f1"], () => {
+    const count = KeySwift.store.get("count", 0) + 1;
+    KeySwift.store.set("count", count);
+    KeySwift.store.set("apps", {kitty: {vim: true}});
+    KeySwift.sendKeys([String(count)]);
+});
+KeySwift.onKeyPress(["f2"], () => {
+    KeySwift.store.delete("count");
+    KeySwift.store.set("fn", () => 1);
+    if (KeySwift.store.get("apps").kitty.vim && KeySwift.store.get("fn") === undefined) {
+        KeySwift.sendKeys(["f3"]);
+    }
+});
+`
+	e := newTestEngine(t, script)
+	for _, key := range []string{"1", "2"} {
+		b := &fakeBus{pressed: mustKeys(t, "f1")}
+		must.NoError(e.Run(b))
+		must.Equal([][]keys.Key{mustKeys(t, key)}, b.sent)
+	}
+	// the changes are written when the engine is released
+	e.Release()
+	data, err := os.ReadFile(filepath.Join(dir, "keyswift", "store.json"))
+	must.NoError(err)
+	must.JSONEq(`{"count": 2, "apps": {"kitty": {"vim": true}}}`, string(data))
+
+	// the store survives a restart
+	e = newTestEngine(t, script)
+	b := &fakeBus{pressed: mustKeys(t, "f1")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "3")}, b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "f2")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f3")}, b.sent)
+	b = &fakeBus{pressed: mustKeys(t, "f1")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "1")}, b.sent)
+}
+
+func TestQuickJSWindowFocus(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onWindowFocus((win, prev) => {
+    KeySwift.typeText(win.class + ":" + win.title + "<" + (prev === null ? "none" : prev.class));
+});
+`)
+	b := &fakeBus{}
+	must.NoError(e.RunWindowFocus(b, &Window{Title: "~", Class: "kitty"}, nil))
+	must.NoError(e.RunWindowFocus(b, &Window{Title: "Inbox", Class: "firefox"}, &Window{Title: "~", Class: "kitty"}))
+	must.Equal("kitty:~<nonefirefox:Inbox<kitty", b.typed)
+}
+
+func TestQuickJSWhen(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.when({class: /^jetbrains-/i}, () => {
+    KeySwift.onKeyPress(["f1"], () => KeySwift.sendKeys(["f2"]));
+    KeySwift.when({title: ["*.go", "*.go *"]}, () => {
+        KeySwift.onKeyPress(["f3"], () => KeySwift.sendKeys(["f4"]));
+    });
+});
+KeySwift.onKeyPress(["f5"], () => {
+    const win = KeySwift.getActiveWindow();
+    KeySwift.typeText(win.class + ":" + win.title);
+});
+KeySwift.when({class: "firefox"}, () => KeySwift.onCombo(["j", "k"], () => {}));
+`)
+	must.Empty(e.Combos())
+
+	for _, c := range []struct {
+		class, title, key string
+		sent              [][]keys.Key
+	}{
+		{"jetbrains-goland", "main.go", "f1", [][]keys.Key{mustKeys(t, "f2")}},
+		{"kitty", "main.go", "f1", nil},
+		{"JetBrains-GoLand", "main.go.orig", "f3", nil},
+		{"JetBrains-GoLand", "main.go *", "f3", [][]keys.Key{mustKeys(t, "f4")}},
+		{"jetbrains-goland", "README.md", "f3", nil},
+	} {
+		b := &fakeBus{windowClass: c.class, windowTitle: c.title, pressed: mustKeys(t, c.key)}
+		must.NoError(e.Run(b))
+		must.Equal(c.sent, b.sent, "%s %s %s", c.class, c.title, c.key)
+	}
+
+	b := &fakeBus{windowClass: "kitty", windowTitle: "~", pressed: mustKeys(t, "f5")}
+	must.NoError(e.Run(b))
+	must.Equal("kitty:~", b.typed)
+}
+
+func TestQuickJSWhenRefused(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.when({class: "kitty"}, () => {
+    KeySwift.onTapHold("capslock", {tap: ["esc"], hold: ["ctrl"]});
+    KeySwift.setLeader(["space"]);
+    KeySwift.onSequence(["ctrl+k", "ctrl+c"], () => KeySwift.sendKeys(["f1"]));
+    KeySwift.onCombo(["j", "k"], () => {});
+    KeySwift.layer("nav", {h: "left"}, {toggle: "f2"});
+    KeySwift.setStickyModifiers(["shift"]);
+    KeySwift.hotstring("btw", "by the way");
+    KeySwift.setLayout("de");
+    KeySwift.setUnicodeMethod("ctrl-shift-u-enter");
+    KeySwift.setKeyRepeat(false);
+    KeySwift.onWindowFocus(() => KeySwift.typeText("focus"));
+    KeySwift.onModifierTap("l-shift", () => {});
+    KeySwift.suppressModifierTaps(["cmd"]);
+    KeySwift.setModifierPolicy(["ctrl"], "never");
+    KeySwift.dbus.onSignal({member: "PropertiesChanged"}, () => {});
+    KeySwift.onKeyPress(["f3"], () => KeySwift.sendKeys(["f4"]));
+});
+`)
+	_, ok := e.TapHold(mustKeys(t, "capslock")[0])
+	must.False(ok)
+	must.Empty(e.Combos())
+	_, ok = e.Layer("nav")
+	must.False(ok)
+	must.False(e.IsStickyModifier(mustKeys(t, "leftshift")[0]))
+	must.Empty(e.Hotstrings())
+	name, _ := e.KeyboardLayout()
+	must.Empty(name)
+	must.Equal(UnicodeCtrlShiftU, e.UnicodeMethod("kitty"))
+	must.True(e.KeyRepeat().Enabled)
+	_, ok = e.ModifierTap(mustKeys(t, "leftshift")[0])
+	must.False(ok)
+	must.False(e.IsTapSuppressed(mustKeys(t, "leftmeta")[0]))
+	must.Equal(ModifierEager, e.ModifierPolicy(mustKeys(t, "leftctrl")[0], "kitty"))
+
+	d := &fakeDBus{}
+	e.SetDBus(d)
+	must.Empty(d.handlers)
+
+	b := &fakeBus{windowClass: "kitty"}
+	must.NoError(e.RunWindowFocus(b, &Window{Class: "kitty"}, nil))
+	must.Empty(b.typed)
+
+	for _, key := range []string{"leftctrl", "k"} {
+		b = &fakeBus{windowClass: "kitty", pressed: mustKeys(t, "leftctrl", key)}
+		must.NoError(e.Run(b))
+		must.Zero(b.pending)
+	}
+
+	// the key bindings are still scoped
+	b = &fakeBus{windowClass: "kitty", pressed: mustKeys(t, "f3")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "f4")}, b.sent)
+}
+
+func TestQuickJSDevices(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["leftalt"], () => KeySwift.sendKeys(["leftmeta"]), {devices: ["AT Translated*"]});
+KeySwift.onKeyPress(["f1"], () => KeySwift.typeText(KeySwift.getDevice()?.name ?? "none"));
+KeySwift.onCombo(["j", "k"], () => {}, {devices: [/HHKB/]});
+`)
+	laptop := &Device{Name: "AT Translated Set 2 keyboard", Path: "/dev/input/event3"}
+	hhkb := &Device{Name: "PFU Limited HHKB-Hybrid", Path: "/dev/input/event7"}
+
+	b := &fakeBus{pressed: mustKeys(t, "leftalt"), device: laptop}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "leftmeta")}, b.sent)
+	b = &fakeBus{pressed: mustKeys(t, "leftalt"), device: hhkb}
+	must.NoError(e.Run(b))
+	must.Empty(b.sent)
+
+	b = &fakeBus{pressed: mustKeys(t, "f1"), device: hhkb}
+	must.NoError(e.Run(b))
+	b.device = nil
+	must.NoError(e.Run(b))
+	must.Equal("PFU Limited HHKB-Hybridnone", b.typed)
+
+	combos := e.Combos()
+	must.Len(combos, 1)
+	must.True(combos[0].Devices.Match(hhkb))
+	must.False(combos[0].Devices.Match(laptop))
+}
+
+func TestQuickJSModifierSides(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["ctrl", "a"], () => KeySwift.sendKeys(["home"]));
+KeySwift.onKeyPress(["r-ctrl", "e"], () => KeySwift.sendKeys(["end"]));
+KeySwift.onKeyPress(["cmd", "shift", "t"], () => KeySwift.sendKeys(["f1"]));
+`)
+	for _, c := range []struct {
+		pressed []string
+		sent    [][]keys.Key
+	}{
+		{[]string{"leftctrl", "a"}, [][]keys.Key{mustKeys(t, "home")}},
+		{[]string{"rightctrl", "a"}, [][]keys.Key{mustKeys(t, "home")}},
+		{[]string{"rightctrl", "e"}, [][]keys.Key{mustKeys(t, "end")}},
+		{[]string{"leftctrl", "e"}, nil},
+		{[]string{"rightmeta", "leftshift", "t"}, [][]keys.Key{mustKeys(t, "f1")}},
+		{[]string{"leftctrl", "rightctrl", "a"}, nil},
+	} {
+		b := &fakeBus{pressed: mustKeys(t, c.pressed...)}
+		must.NoError(e.Run(b))
+		must.Equal(c.sent, b.sent, "%v", c.pressed)
+	}
+}
+
+func TestQuickJSSequenceModifierSides(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onSequence(["ctrl+k", "ctrl+c"], () => KeySwift.sendKeys(["f1"]));
+KeySwift.onSequence(["ctrl+k", "r-ctrl+u"], () => KeySwift.sendKeys(["f2"]));
+KeySwift.onSequence(["ctrl+k", "ctrl+u"], () => KeySwift.sendKeys(["f3"]));
+`)
+	for _, c := range []struct {
+		steps [][]string
+		sent  [][]keys.Key
+	}{
+		{[][]string{{"rightctrl", "k"}, {"rightctrl", "c"}}, [][]keys.Key{mustKeys(t, "f1")}},
+		{[][]string{{"leftctrl", "k"}, {"rightctrl", "c"}}, [][]keys.Key{mustKeys(t, "f1")}},
+		{[][]string{{"leftctrl", "k"}, {"rightctrl", "u"}}, [][]keys.Key{mustKeys(t, "f2")}},
+		{[][]string{{"rightctrl", "k"}, {"leftctrl", "u"}}, [][]keys.Key{mustKeys(t, "f3")}},
+	} {
+		var b *fakeBus
+		for _, step := range c.steps {
+			b = &fakeBus{pressed: mustKeys(t, step...)}
+			must.NoError(e.Run(b))
+		}
+		must.True(b.completed, "%v", c.steps)
+		must.Equal(c.sent, b.sent, "%v", c.steps)
+	}
+}
+
+func TestQuickJSSequenceDevices(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `KeySwift.onSequence(["ctrl+k", "ctrl+c"], () => KeySwift.sendKeys(["f1"]));`)
+	laptop := &Device{Name: "AT Translated Set 2 keyboard"}
+	hhkb := &Device{Name: "PFU Limited HHKB-Hybrid"}
+
+	for _, step := range []struct {
+		device  *Device
+		key     string
+		pending bool
+	}{
+		{laptop, "k", true},
+		{hhkb, "k", true},
+		{hhkb, "c", false},
+		{laptop, "c", false},
+	} {
+		b := &fakeBus{pressed: mustKeys(t, "leftctrl", step.key), device: step.device}
+		must.NoError(e.Run(b))
+		must.Equal(step.pending, b.pending > 0, "%s %s", step.device.Name, step.key)
+		must.Equal(!step.pending, b.completed, "%s %s", step.device.Name, step.key)
+	}
+
+	// the timeout ends the sequence of its device
+	must.NoError(e.Run(&fakeBus{pressed: mustKeys(t, "leftctrl", "k"), device: laptop}))
+	b := &fakeBus{device: hhkb}
+	must.NoError(e.ExpireSequence(b))
+	must.False(b.aborted)
+	b = &fakeBus{device: laptop}
+	must.NoError(e.ExpireSequence(b))
+	must.True(b.aborted)
+}
+
+func TestQuickJSOrderedChord(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onKeyPress(["s", "t"], () => KeySwift.typeText("st"), {ordered: true});
+KeySwift.onKeyPress(["t", "s"], () => KeySwift.typeText("ts"), {ordered: true});
+KeySwift.onKeyPress(["ctrl", "k", "j"], () => KeySwift.typeText("ckj"), {ordered: true});
+`)
+	for _, c := range []struct {
+		pressed []string
+		typed   string
+	}{
+		{[]string{"s", "t"}, "st"},
+		{[]string{"t", "s"}, "ts"},
+		{[]string{"rightctrl", "k", "j"}, "ckj"},
+		{[]string{"rightctrl", "j", "k"}, ""},
+		{[]string{"k", "leftctrl", "j"}, ""},
+	} {
+		b := &fakeBus{pressed: mustKeys(t, c.pressed...)}
+		must.NoError(e.Run(b))
+		must.Equal(c.typed, b.typed, "%v", c.pressed)
+	}
+}
+
+func TestQuickJSModifierTap(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.onModifierTap("l-shift", () => KeySwift.typeText("("));
+KeySwift.onModifierTap("cmd", () => KeySwift.sendKeys(["f1"]), {timeoutMs: 300});
+KeySwift.onModifierTap("a", () => {});
+KeySwift.suppressModifierTaps(["cmd", "r-alt"]);
+`)
+	tap, ok := e.ModifierTap(mustKeys(t, "leftshift")[0])
+	must.True(ok)
+	must.Equal(DefaultModifierTapTimeout, tap.Timeout)
+	_, ok = e.ModifierTap(mustKeys(t, "rightshift")[0])
+	must.False(ok)
+	_, ok = e.ModifierTap(mustKeys(t, "a")[0])
+	must.False(ok)
+
+	for _, name := range []string{"leftmeta", "rightmeta"} {
+		tap, ok = e.ModifierTap(mustKeys(t, name)[0])
+		must.True(ok)
+		must.Equal(300*time.Millisecond, tap.Timeout)
+		must.True(e.IsTapSuppressed(mustKeys(t, name)[0]))
+	}
+	must.True(e.IsTapSuppressed(mustKeys(t, "rightalt")[0]))
+	must.False(e.IsTapSuppressed(mustKeys(t, "leftalt")[0]))
+
+	b := &fakeBus{}
+	must.NoError(e.RunModifierTap(b, tap.ID))
+	must.Equal([][]keys.Key{mustKeys(t, "f1")}, b.sent)
+}
+
+func TestQuickJSModifierPolicy(t *testing.T) {
+	must := require.New(t)
+	e := newTestEngine(t, `
+KeySwift.setModifierPolicy(["cmd"], "never");
+KeySwift.setModifierPolicy(["ctrl", "alt"], "lazy", {windowClass: ["steam_app_570", "org.remmina.Remmina"]});
+KeySwift.setModifierPolicy(["shift"], "sometimes");
+`)
+	for _, c := range []struct {
+		key, class string
+		policy     ModifierPolicy
+	}{
+		{"leftctrl", "firefox", ModifierEager},
+		{"rightalt", "firefox", ModifierEager},
+		{"leftshift", "firefox", ModifierLazy},
+		{"rightmeta", "firefox", ModifierNever},
+		{"rightctrl", "org.remmina.Remmina", ModifierLazy},
+		{"leftalt", "steam_app_570", ModifierLazy},
+		{"leftmeta", "steam_app_570", ModifierNever},
+	} {
+		must.Equal(c.policy, e.ModifierPolicy(mustKeys(t, c.key)[0], c.class), "%s %s", c.key, c.class)
+	}
+}
+
+func TestQuickJSModules(t *testing.T) {
+	must := require.New(t)
+	dir := t.TempDir()
+	must.NoError(os.MkdirAll(filepath.Join(dir, "presets"), 0o755))
+	must.NoError(os.WriteFile(filepath.Join(dir, "presets", "macos.js"), []byte(`
+import {bind} from "./util.js";
+export const macosLike = () => bind(["cmd", "c"], ["ctrl", "c"]);
+`), 0o644))
+	must.NoError(os.WriteFile(filepath.Join(dir, "presets", "util.js"), []byte(`
+export const bind = (from, to) => KeySwift.onKeyPress(from, () => KeySwift.sendKeys(to));
+`), 0o644))
+	path := filepath.Join(dir, "config.js")
+
+	e, err := NewQuickJS(`import {macosLike} from "./presets/macos.js"; macosLike();`, WithScriptPath(path))
+	must.NoError(err)
+	defer e.Release()
+	b := &fakeBus{pressed: mustKeys(t, "leftmeta", "c")}
+	must.NoError(e.Run(b))
+	must.Equal([][]keys.Key{mustKeys(t, "c", "ctrl")}, b.sent)
+
+	_, err = NewQuickJS(`import {linux} from "./presets/linux.js";`, WithScriptPath(path))
+	must.ErrorContains(err, filepath.Join(dir, "presets", "linux.js"))
+
+	_, err = NewQuickJS(`import "./presets/util.js"; throw new Error("boom");`, WithScriptPath(path))
+	must.ErrorContains(err, "boom")
+}
+
+func TestQuickJSBuiltinModules(t *testing.T) {
+	must := require.New(t)
+	dir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(dir, "timer.js"), []byte(`
+import * as os from "os";
+export const later = (fn) => os.setTimeout(fn, 1000);
+`), 0o644))
+	path := filepath.Join(dir, "config.js")
+
+	_, err := NewQuickJS(`import * as std from "std";`, WithScriptPath(path))
+	must.ErrorContains(err, "imports the std module")
+
+	_, err = NewQuickJS(`import {later} from "./timer.js"; later(() => {});`, WithScriptPath(path))
+	must.ErrorContains(err, filepath.Join(dir, "timer.js")+" imports the os module")
+
+	_, err = NewQuickJS(`const name = "o" + "s"; await import(name);`, WithScriptPath(path))
+	must.ErrorContains(err, "imports a computed path")
+}
+
+func TestModuleFiles(t *testing.T) {
+	must := require.New(t)
+	dir := t.TempDir()
+	must.NoError(os.MkdirAll(filepath.Join(dir, "presets"), 0o755))
+	must.NoError(os.WriteFile(filepath.Join(dir, "config.js"), []byte(`
+import * as os from "os";
+import {macosLike} from "./presets/macos.js";
+import "./presets/macos.js";
+export * from './shared.js';
+macosLike();
+`), 0o644))
+	must.NoError(os.WriteFile(filepath.Join(dir, "presets", "macos.js"), []byte(`
+import {
+    bind,
+    unbind as drop,
+} from "../presets/util.js";
+const vim = await import("./vim.js");
+export const macosLike = () => bind(["cmd", "c"], ["ctrl", "c"]);
+`), 0o644))
+	must.NoError(os.WriteFile(filepath.Join(dir, "presets", "util.js"), []byte(`
+export const bind = (from, to) => KeySwift.onKeyPress(from, () => KeySwift.sendKeys(to));
+`), 0o644))
+
+	must.Equal([]string{
+		filepath.Join(dir, "config.js"),
+		filepath.Join(dir, "presets", "macos.js"),
+		filepath.Join(dir, "shared.js"),
+		filepath.Join(dir, "presets", "util.js"),
+		filepath.Join(dir, "presets", "vim.js"),
+	}, ModuleFiles(filepath.Join(dir, "config.js")))
+}
+
+func TestQuickJSCallbackError(t *testing.T) {
+	e := newTestEngine(t, `KeySwift.onKeyPress(["a"], () => { throw new Error("boom") });`)
+	require.ErrorContains(t, e.Run(&fakeBus{pressed: mustKeys(t, "a")}), "boom")
+}
+
+func TestQuickJSBadScript(t *testing.T) {
+	_, err := NewQuickJS(`KeySwift.onKeyPress(`)
+	require.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+)
+
+// registerRecorder registers KeySwift.startRecording(register, {persist}), KeySwift.stopRecording(),
+// KeySwift.isRecording() and KeySwift.replay(register, {timing, keyDelayMs})
+func (e *QuickJS) registerRecorder(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncStartRecording, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("startRecording requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		if e.session == nil {
+			slog.Error("startRecording should be called inside a callback")
+			return ctx.Undefined()
+		}
+
+		persist := len(args) == 2 && args[1].IsObject() && optionBool(args[1], "persist")
+		if err := e.session.StartRecording(args[0].String(), persist); err != nil {
+			slog.Error("failed to start recording", "error", err)
+		}
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncStopRecording, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if e.session == nil {
+			slog.Error("stopRecording should be called inside a callback")
+			return ctx.Undefined()
+		}
+
+		if err := e.session.StopRecording(); err != nil {
+			slog.Error("failed to stop recording", "error", err)
+		}
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncIsRecording, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if e.session == nil {
+			slog.Error("isRecording should be called inside a callback")
+			return ctx.Bool(false)
+		}
+		return ctx.Bool(e.session.IsRecording())
+	}))
+
+	keySwift.Set(FuncReplay, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("replay requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		if e.session == nil {
+			slog.Error("replay should be called inside a callback")
+			return ctx.Undefined()
+		}
+
+		opts := ReplayOptions{OriginalTiming: true, KeyDelay: DefaultMacroKeyDelay}
+		if len(args) == 2 && args[1].IsObject() {
+			timing := args[1].Get("timing")
+			switch {
+			case timing.IsUndefined(), timing.String() == "original":
+			case timing.String() == "compressed":
+				opts.OriginalTiming = false
+			default:
+				slog.Error("unknown replay timing", "timing", timing.String())
+				timing.Free()
+				return ctx.Undefined()
+			}
+			timing.Free()
+			opts.KeyDelay = optionDuration(args[1], "keyDelayMs", DefaultMacroKeyDelay)
+		}
+
+		if err := e.session.Replay(args[0].String(), opts); err != nil {
+			slog.Error("failed to replay", "error", err)
+		}
+		return ctx.Undefined()
+	}))
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+const (
+	DefaultKeyRepeatDelay    = 500 * time.Millisecond
+	DefaultKeyRepeatInterval = 30 * time.Millisecond
+)
+
+func (e *QuickJS) KeyRepeat() KeyRepeat {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.keyRepeat
+}
+
+// registerKeyRepeat registers KeySwift.setKeyRepeat({delayMs, intervalMs}) and KeySwift.setKeyRepeat(false)
+func (e *QuickJS) registerKeyRepeat(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncSetKeyRepeat, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetKeyRepeat) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 || (!args[0].IsObject() && !args[0].IsBool()) {
+			slog.Error("setKeyRepeat requires an object or a boolean")
+			return ctx.Undefined()
+		}
+
+		repeat := KeyRepeat{
+			Enabled:  true,
+			Delay:    DefaultKeyRepeatDelay,
+			Interval: DefaultKeyRepeatInterval,
+		}
+		if args[0].IsBool() {
+			repeat.Enabled = args[0].Bool()
+		} else {
+			repeat.Delay = optionDuration(args[0], "delayMs", DefaultKeyRepeatDelay)
+			repeat.Interval = optionDuration(args[0], "intervalMs", DefaultKeyRepeatInterval)
+		}
+		if repeat.Delay <= 0 || repeat.Interval <= 0 {
+			slog.Error("key repeat delay and interval must be positive", "delay", repeat.Delay, "interval", repeat.Interval)
+			return ctx.Undefined()
+		}
+		slog.Debug("set key repeat", "repeat", repeat)
+
+		e.mu.Lock()
+		e.keyRepeat = repeat
+		e.mu.Unlock()
+		return ctx.Undefined()
+	}))
+}
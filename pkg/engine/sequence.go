@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buke/quickjs-go"
+	"github.com/jialeicui/golibevdev"
+	"github.com/samber/lo"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const (
+	DefaultSequenceTimeout = time.Second
+
+	// leaderStep is the name of the leader key in the steps of a sequence
+	leaderStep = "leader"
+)
+
+// sequenceStep is a chord of a key sequence
+type sequenceStep struct {
+	keys []keys.Key
+	// sides are the modifiers bound to a side, like "r-ctrl", they must be pressed as is
+	sides []keys.Key
+}
+
+// sequenceNode is a node of the key sequence prefix trie, each edge is a chord.
+// The edges are keyed by the chord with the modifiers of either side being the same,
+// the children sharing an edge differ in the sides of their modifiers.
+type sequenceNode struct {
+	children map[[maxPressed]golibevdev.KeyEventCode][]*sequenceNode
+	// sides are the sided modifiers of the edge leading to this node
+	sides []keys.Key
+	// bindings are the callbacks of the sequences ending at this node
+	bindings []binding
+	// timeout is how long to wait for the next chord
+	timeout time.Duration
+}
+
+func newSequenceNode(sides []keys.Key) *sequenceNode {
+	return &sequenceNode{
+		children: make(map[[maxPressed]golibevdev.KeyEventCode][]*sequenceNode),
+		sides:    sides,
+	}
+}
+
+func (n *sequenceNode) add(steps []sequenceStep, b binding, timeout time.Duration) {
+	node := n
+	for _, step := range steps {
+		k := modifierChordKey(step.keys)
+		sides := slices.Clone(step.sides)
+		slices.Sort(sides)
+		i := slices.IndexFunc(node.children[k], func(child *sequenceNode) bool {
+			return slices.Equal(child.sides, sides)
+		})
+		var child *sequenceNode
+		if i < 0 {
+			child = newSequenceNode(sides)
+			node.children[k] = append(node.children[k], child)
+			// the most specific sides are tried first
+			slices.SortStableFunc(node.children[k], func(a, b *sequenceNode) int {
+				return len(b.sides) - len(a.sides)
+			})
+		} else {
+			child = node.children[k][i]
+		}
+		node.timeout = max(node.timeout, timeout)
+		node = child
+	}
+	node.bindings = append(node.bindings, b)
+}
+
+// next returns the child matching the pressed chord
+func (n *sequenceNode) next(pressed []keys.Key) (*sequenceNode, bool) {
+	for _, child := range n.children[modifierChordKey(pressed)] {
+		if lo.Every(pressed, child.sides) {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+func (n *sequenceNode) free() {
+	for _, b := range n.bindings {
+		b.fn.Free()
+	}
+	for _, children := range n.children {
+		for _, child := range children {
+			child.free()
+		}
+	}
+}
+
+// runSequence advances the pending key sequence of the device with the pressed chord,
+// returns true if the event is consumed by a sequence
+func (e *QuickJS) runSequence(session Bus) (bool, error) {
+	pressed := session.GetPressedKeys()
+	device := session.GetDevice()
+	if pending := e.pendingSequences[device]; pending != nil {
+		// pressing a modifier of the next chord doesn't break the sequence
+		if lo.EveryBy(pressed, keys.IsModifier) {
+			return true, nil
+		}
+
+		next, ok := pending.next(pressed)
+		if ok {
+			return true, e.enterSequence(session, next)
+		}
+
+		slog.Debug("sequence aborted", "keys", pressed)
+		delete(e.pendingSequences, device)
+		session.AbortSequence()
+	}
+
+	next, ok := e.sequences.next(pressed)
+	if !ok {
+		return false, nil
+	}
+	return true, e.enterSequence(session, next)
+}
+
+func (e *QuickJS) enterSequence(session Bus, node *sequenceNode) error {
+	if len(node.children) == 0 {
+		delete(e.pendingSequences, session.GetDevice())
+		session.CompleteSequence()
+		return e.runBindings(session, node.bindings)
+	}
+
+	e.pendingSequences[session.GetDevice()] = node
+	session.PendSequence(node.timeout)
+	return nil
+}
+
+// ExpireSequence ends the pending key sequence of the device of the session after its timeout.
+// The sequence ending at the matched prefix is completed, otherwise it's aborted.
+func (e *QuickJS) ExpireSequence(session Bus) error {
+	return e.do(func() error {
+		device := session.GetDevice()
+		node := e.pendingSequences[device]
+		if node == nil {
+			return nil
+		}
+		delete(e.pendingSequences, device)
+
+		if len(node.bindings) == 0 {
+			session.AbortSequence()
+			return nil
+		}
+		session.CompleteSequence()
+		return e.runBindings(session, node.bindings)
+	})
+}
+
+// parseSequenceStep converts a step of a sequence to a chord and its sided modifiers.
+// A step is an array of key names, a key name, a chord like "ctrl+x" or "leader".
+func (e *QuickJS) parseSequenceStep(v quickjs.Value) (sequenceStep, error) {
+	var names []string
+	if v.IsString() {
+		name := v.String()
+		if name == leaderStep {
+			if len(e.leader.keys) == 0 {
+				return sequenceStep{}, errors.New("leader key is not set, call setLeader first")
+			}
+			return e.leader, nil
+		}
+		names = strings.Split(name, "+")
+	} else {
+		var err error
+		if names, err = getStrings(v); err != nil {
+			return sequenceStep{}, err
+		}
+	}
+
+	codes, err := e.keyCodes(names)
+	if err != nil {
+		return sequenceStep{}, err
+	}
+	return sequenceStep{keys: codes, sides: e.sidedModifiers(names)}, nil
+}
+
+// registerSequence registers
+// KeySwift.setLeader(keys) and KeySwift.onSequence(steps, callback, {timeoutMs})
+func (e *QuickJS) registerSequence(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncSetLeader, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetLeader) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 {
+			slog.Error("setLeader requires one argument")
+			return ctx.Undefined()
+		}
+
+		leader, err := e.parseSequenceStep(args[0])
+		if err != nil {
+			slog.Error("failed to get leader key", "error", err)
+			return ctx.Undefined()
+		}
+		e.leader = leader
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncOnSequence, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncOnSequence) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 && len(args) != 3 {
+			slog.Error("onSequence requires two or three arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsArray() {
+			slog.Error("onSequence requires an array of steps as the first argument")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsFunction() {
+			slog.Error("onSequence requires a function as the second argument")
+			return ctx.Undefined()
+		}
+
+		jsSteps := args[0].ToArray()
+		steps := make([]sequenceStep, 0, jsSteps.Len())
+		for i := int64(0); i < jsSteps.Len(); i++ {
+			item, err := jsSteps.Get(i)
+			if err != nil {
+				slog.Error("failed to get step by index", "error", err, "index", i)
+				return ctx.Undefined()
+			}
+			step, err := e.parseSequenceStep(item)
+			item.Free()
+			if err != nil {
+				slog.Error("failed to parse step", "error", fmt.Errorf("step %d: %w", i, err))
+				return ctx.Undefined()
+			}
+			steps = append(steps, step)
+		}
+		if len(steps) == 0 {
+			slog.Error("onSequence requires at least one step")
+			return ctx.Undefined()
+		}
+
+		timeout := DefaultSequenceTimeout
+		if len(args) == 3 && args[2].IsObject() {
+			timeout = optionDuration(args[2], "timeoutMs", DefaultSequenceTimeout)
+		}
+
+		slog.Debug("add sequence", "steps", steps, "timeout", timeout)
+		e.sequences.add(steps, binding{fn: e.retain(args[1])}, timeout)
+		return ctx.Undefined()
+	}))
+}
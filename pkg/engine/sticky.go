@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+func (e *QuickJS) IsStickyModifier(key keys.Key) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.sticky[key]
+	return ok
+}
+
+// registerSticky registers KeySwift.setStickyModifiers(keys)
+func (e *QuickJS) registerSticky(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncSetStickyModifiers, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetStickyModifiers) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 {
+			slog.Error("setStickyModifiers requires one argument")
+			return ctx.Undefined()
+		}
+
+		names, err := getStrings(args[0])
+		if err != nil {
+			slog.Error("failed to get key names", "error", err)
+			return ctx.Undefined()
+		}
+
+		// a generic name like "shift" makes both sides sticky
+		codes, err := e.modifierCodes(names)
+		if err != nil {
+			slog.Error("sticky key must be a modifier", "error", err)
+			return ctx.Undefined()
+		}
+
+		sticky := make(map[keys.Key]struct{}, len(codes))
+		for _, code := range codes {
+			sticky[code] = struct{}{}
+		}
+		slog.Debug("set sticky modifiers", "keys", codes)
+
+		e.mu.Lock()
+		e.sticky = sticky
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+}
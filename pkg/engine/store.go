@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/store"
+)
+
+// openStore opens the store on the first use, it runs on the js goroutine
+func (e *QuickJS) openStore() (*store.Store, error) {
+	if e.store == nil {
+		path, err := store.DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		s, err := store.OpenShared(path)
+		if err != nil {
+			return nil, err
+		}
+		e.store = s
+	}
+	return e.store, nil
+}
+
+// flushStore writes the changes of the store not written yet, it runs when the engine is released
+func (e *QuickJS) flushStore() {
+	if e.store == nil {
+		return
+	}
+	if err := e.store.Flush(); err != nil {
+		slog.Error("failed to save store", "error", err)
+	}
+}
+
+// stringify converts the value to JSON
+func (e *QuickJS) stringify(v quickjs.Value) (string, error) {
+	jsonObj := e.ctx.Globals().Get("JSON")
+	defer jsonObj.Free()
+	ret := jsonObj.Call("stringify", v)
+	defer ret.Free()
+	if ret.IsException() {
+		return "", e.ctx.Exception()
+	}
+	if !ret.IsString() {
+		return "", fmt.Errorf("%s can't be converted to JSON", v.String())
+	}
+	return ret.String(), nil
+}
+
+// registerStore registers KeySwift.store.get(key, defaultValue), KeySwift.store.set(key, value)
+// and KeySwift.store.delete(key)
+func (e *QuickJS) registerStore(ctx *quickjs.Context, keySwift quickjs.Value) {
+	storeObj := ctx.Object()
+	keySwift.Set(StoreObj, storeObj)
+
+	storeObj.Set(FuncStoreGet, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("store.get requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		s, err := e.openStore()
+		if err != nil {
+			slog.Error("failed to open store", "error", err)
+			return ctx.Undefined()
+		}
+
+		v, ok := s.Get(args[0].String())
+		if ok {
+			return ctx.ParseJSON(string(v))
+		}
+		if len(args) == 2 {
+			return e.retain(args[1])
+		}
+		return ctx.Undefined()
+	}))
+
+	storeObj.Set(FuncStoreSet, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 2 {
+			slog.Error("store.set requires two arguments")
+			return ctx.Undefined()
+		}
+
+		s, err := e.openStore()
+		if err != nil {
+			slog.Error("failed to open store", "error", err)
+			return ctx.Undefined()
+		}
+
+		v, err := e.stringify(args[1])
+		if err != nil {
+			slog.Error("failed to set store value", "key", args[0].String(), "error", err)
+			return ctx.Undefined()
+		}
+		if err = s.Set(args[0].String(), json.RawMessage(v)); err != nil {
+			slog.Error("failed to set store value", "key", args[0].String(), "error", err)
+		}
+		return ctx.Undefined()
+	}))
+
+	storeObj.Set(FuncStoreDelete, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 {
+			slog.Error("store.delete requires one argument")
+			return ctx.Undefined()
+		}
+
+		s, err := e.openStore()
+		if err != nil {
+			slog.Error("failed to open store", "error", err)
+			return ctx.Undefined()
+		}
+
+		s.Delete(args[0].String())
+		return ctx.Undefined()
+	}))
+}
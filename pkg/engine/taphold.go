@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+const DefaultTapHoldTimeout = 200 * time.Millisecond
+
+func (e *QuickJS) TapHold(key keys.Key) (TapHold, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	th, ok := e.tapHolds[key]
+	return th, ok
+}
+
+// registerTapHold registers KeySwift.onTapHold(key, {tap, hold, timeoutMs, permissiveHold, holdOnOtherKeyPress})
+func (e *QuickJS) registerTapHold(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncOnTapHold, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncOnTapHold) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 2 {
+			slog.Error("onTapHold requires two arguments")
+			return ctx.Undefined()
+		}
+
+		if !args[0].IsString() {
+			slog.Error("onTapHold requires a key name as the first argument")
+			return ctx.Undefined()
+		}
+
+		if !args[1].IsObject() {
+			slog.Error("onTapHold requires an options object as the second argument")
+			return ctx.Undefined()
+		}
+
+		key, err := keys.GetKeyCodes([]string{args[0].String()})
+		if err != nil {
+			slog.Error("failed to get key code", "error", err)
+			return ctx.Undefined()
+		}
+
+		tap, err := e.optionKeyCodes(args[1], "tap")
+		if err != nil {
+			slog.Error("failed to get tap keys", "error", err)
+			return ctx.Undefined()
+		}
+
+		hold, err := e.optionKeyCodes(args[1], "hold")
+		if err != nil {
+			slog.Error("failed to get hold keys", "error", err)
+			return ctx.Undefined()
+		}
+
+		th := TapHold{
+			Key:                 key[0],
+			Tap:                 tap,
+			Hold:                hold,
+			Timeout:             optionDuration(args[1], "timeoutMs", DefaultTapHoldTimeout),
+			PermissiveHold:      optionBool(args[1], "permissiveHold"),
+			HoldOnOtherKeyPress: optionBool(args[1], "holdOnOtherKeyPress"),
+		}
+		slog.Debug("add tap hold", "tapHold", th)
+
+		e.mu.Lock()
+		e.tapHolds[th.Key] = th
+		e.mu.Unlock()
+
+		return ctx.Undefined()
+	}))
+}
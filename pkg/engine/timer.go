@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/buke/quickjs-go"
+)
+
+// timer is a callback scheduled by setTimeout or setInterval
+type timer struct {
+	fn   quickjs.Value
+	args []quickjs.Value
+	// interval is zero for setTimeout
+	interval time.Duration
+	t        *time.Timer
+}
+
+func (t *timer) free() {
+	t.t.Stop()
+	t.fn.Free()
+	for _, arg := range t.args {
+		arg.Free()
+	}
+}
+
+// SetBusFactory sets the factory of the bus used by the callbacks not triggered by key events, like timers
+func (e *QuickJS) SetBusFactory(factory func() Bus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.busFactory = factory
+}
+
+// schedule arms the timer to fire on the js goroutine after d
+func (e *QuickJS) schedule(id int32, t *timer, d time.Duration) {
+	t.t = time.AfterFunc(d, func() {
+		_ = e.do(func() error {
+			e.fireTimer(id)
+			return nil
+		})
+	})
+}
+
+// fireTimer invokes the callback of the timer, it runs on the js goroutine
+func (e *QuickJS) fireTimer(id int32) {
+	t, ok := e.timers[id]
+	if !ok {
+		// cleared after it fired
+		return
+	}
+	if t.interval > 0 {
+		e.schedule(id, t, t.interval)
+	} else {
+		delete(e.timers, id)
+		defer t.free()
+	}
+
+	if err := e.invokeDetached(t.fn, t.args...); err != nil {
+		slog.Error("timer callback failed", "error", err)
+	}
+}
+
+// clearTimer cancels the timer, it runs on the js goroutine
+func (e *QuickJS) clearTimer(id int32) {
+	if t, ok := e.timers[id]; ok {
+		delete(e.timers, id)
+		t.free()
+	}
+}
+
+// timerFunc returns the js function of setTimeout or setInterval, it's called as name(callback, delayMs, ...args)
+func (e *QuickJS) timerFunc(name string, repeat bool) func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+	return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) == 0 || !args[0].IsFunction() {
+			slog.Error(name + " requires a function as the first argument")
+			return ctx.Undefined()
+		}
+
+		var d time.Duration
+		if len(args) > 1 && args[1].IsNumber() {
+			d = time.Duration(args[1].Float64() * float64(time.Millisecond))
+		}
+		if repeat {
+			// an interval of zero would spin the js goroutine
+			d = max(d, time.Millisecond)
+		} else {
+			d = max(d, 0)
+		}
+
+		t := &timer{fn: e.retain(args[0])}
+		if len(args) > 2 {
+			for _, arg := range args[2:] {
+				t.args = append(t.args, e.retain(arg))
+			}
+		}
+		if repeat {
+			t.interval = d
+		}
+
+		e.nextTimerID++
+		id := e.nextTimerID
+		e.timers[id] = t
+		e.schedule(id, t, d)
+		return ctx.Int32(id)
+	}
+}
+
+// registerTimers registers the global setTimeout, setInterval, clearTimeout and clearInterval,
+// they replace the ones of the os module which only run in the blocking event loop of quickjs
+func (e *QuickJS) registerTimers(ctx *quickjs.Context) {
+	clearFn := func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) == 1 && args[0].IsNumber() {
+			e.clearTimer(args[0].Int32())
+		}
+		return ctx.Undefined()
+	}
+
+	globals := ctx.Globals()
+	globals.Set("setTimeout", ctx.Function(e.timerFunc("setTimeout", false)))
+	globals.Set("setInterval", ctx.Function(e.timerFunc("setInterval", true)))
+	globals.Set("clearTimeout", ctx.Function(clearFn))
+	globals.Set("clearInterval", ctx.Function(clearFn))
+}
+
+// newBus returns the bus of the callbacks not triggered by key events, nil if the factory is not set
+func (e *QuickJS) newBus() Bus {
+	e.mu.RLock()
+	factory := e.busFactory
+	e.mu.RUnlock()
+	if factory == nil {
+		return nil
+	}
+	return factory()
+}
+
+// invokeDetached invokes a callback not triggered by key events with the bus of the factory,
+// it runs on the js goroutine
+func (e *QuickJS) invokeDetached(fn quickjs.Value, args ...quickjs.Value) error {
+	e.session = e.newBus()
+	defer func() {
+		e.session = nil
+	}()
+	return e.invoke(fn, args...)
+}
+
+// runJobs runs the pending jobs of the promises, it runs on the js goroutine after each task
+func (e *QuickJS) runJobs() {
+	e.session = e.newBus()
+	defer func() {
+		e.session = nil
+	}()
+	// the event loop of quickjs returns once no job is pending, since std and os can't be imported no handler of theirs keeps it running
+	e.ctx.Loop()
+}
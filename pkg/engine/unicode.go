@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/buke/quickjs-go"
+)
+
+func (e *QuickJS) UnicodeMethod(windowClass string) UnicodeMethod {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if method, ok := e.unicodeMethods[windowClass]; ok {
+		return method
+	}
+	return e.unicodeMethod
+}
+
+func (e *QuickJS) KeyboardLayout() (name, variant string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.layoutName, e.layoutVariant
+}
+
+// typeFunc returns the js function typing its string argument with typeFn
+func (e *QuickJS) typeFunc(name string, typeFn func(session Bus, text string)) func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+	return func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 1 || !args[0].IsString() {
+			slog.Error(name + " requires a string")
+			return ctx.Undefined()
+		}
+
+		if e.session == nil {
+			slog.Error(name + " should be called inside a callback")
+			return ctx.Undefined()
+		}
+
+		typeFn(e.session, args[0].String())
+		return ctx.Undefined()
+	}
+}
+
+// registerUnicode registers KeySwift.typeText(text), KeySwift.typeUnicode(text),
+// KeySwift.setLayout(name, variant) and KeySwift.setUnicodeMethod(method, {windowClass})
+func (e *QuickJS) registerUnicode(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncTypeText, ctx.Function(e.typeFunc(FuncTypeText, Bus.TypeText)))
+	keySwift.Set(FuncTypeUnicode, ctx.Function(e.typeFunc(FuncTypeUnicode, Bus.TypeUnicode)))
+
+	keySwift.Set(FuncSetLayout, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetLayout) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("setLayout requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.layoutName = args[0].String()
+		e.layoutVariant = ""
+		if len(args) == 2 && args[1].IsString() {
+			e.layoutVariant = args[1].String()
+		}
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncSetUnicodeMethod, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncSetUnicodeMethod) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 && len(args) != 2 {
+			slog.Error("setUnicodeMethod requires one or two arguments")
+			return ctx.Undefined()
+		}
+
+		method := UnicodeMethod(args[0].String())
+		if !method.Valid() {
+			slog.Error("unknown unicode method", "method", method)
+			return ctx.Undefined()
+		}
+
+		var classes []string
+		if len(args) == 2 && args[1].IsObject() {
+			var err error
+			classes, err = optionStrings(args[1], "windowClass")
+			if err != nil {
+				slog.Error("failed to get window classes", "error", err)
+				return ctx.Undefined()
+			}
+		}
+		slog.Debug("set unicode method", "method", method, "windowClasses", classes)
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if len(classes) == 0 {
+			e.unicodeMethod = method
+		}
+		for _, class := range classes {
+			e.unicodeMethods[class] = method
+		}
+		return ctx.Undefined()
+	}))
+}
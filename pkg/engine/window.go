@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/buke/quickjs-go"
+)
+
+// windowMatch matches the windows by their class and title, an empty list matches any
+type windowMatch struct {
+	class []*regexp.Regexp
+	title []*regexp.Regexp
+}
+
+func (m windowMatch) match(win *Window) bool {
+	if win == nil {
+		win = &Window{}
+	}
+	return matchAny(m.class, win.Class) && matchAny(m.title, win.Title)
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	return len(patterns) == 0 || slices.ContainsFunc(patterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(s)
+	})
+}
+
+// globRegexp converts a glob with * and ? to a regexp matching the whole string
+func globRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// jsRegexp converts a js RegExp to a go regexp, the syntax they share is supported
+func jsRegexp(v quickjs.Value) (*regexp.Regexp, error) {
+	source, flags := v.Get("source"), v.Get("flags")
+	defer source.Free()
+	defer flags.Free()
+
+	var prefix string
+	for _, flag := range flags.String() {
+		switch flag {
+		case 'i', 'm', 's':
+			prefix += string(flag)
+		}
+	}
+	if prefix != "" {
+		prefix = "(?" + prefix + ")"
+	}
+	return regexp.Compile(prefix + source.String())
+}
+
+// patterns parses a glob, a RegExp or an array of them
+func patterns(v quickjs.Value) ([]*regexp.Regexp, error) {
+	switch {
+	case v.IsUndefined():
+		return nil, nil
+	case v.IsString():
+		return []*regexp.Regexp{globRegexp(v.String())}, nil
+	case v.IsArray():
+		arr := v.ToArray()
+		var ret []*regexp.Regexp
+		for i := int64(0); i < arr.Len(); i++ {
+			item, err := arr.Get(i)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pattern by index %d: %w", i, err)
+			}
+			res, err := patterns(item)
+			item.Free()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, res...)
+		}
+		return ret, nil
+	case v.IsObject():
+		re, err := jsRegexp(v)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported RegExp %s: %w", v.String(), err)
+		}
+		return []*regexp.Regexp{re}, nil
+	default:
+		return nil, fmt.Errorf("pattern must be a string or a RegExp: %s", v.String())
+	}
+}
+
+// parseWindowMatch parses {class, title}
+func parseWindowMatch(v quickjs.Value) (windowMatch, error) {
+	var m windowMatch
+	for name, dst := range map[string]*[]*regexp.Regexp{"class": &m.class, "title": &m.title} {
+		field := v.Get(name)
+		res, err := patterns(field)
+		field.Free()
+		if err != nil {
+			return m, fmt.Errorf("%s: %w", name, err)
+		}
+		*dst = res
+	}
+	return m, nil
+}
+
+// unscoped returns false and logs an error inside KeySwift.when, only the bindings of onKeyPress and onKeyRelease
+// are scoped, the keys of the others are consumed or the settings apply before the active window is checked
+func (e *QuickJS) unscoped(name string) bool {
+	if len(e.scope) == 0 {
+		return true
+	}
+	slog.Error("only onKeyPress and onKeyRelease can be scoped by when, check getActiveWindow in the callbacks instead",
+		"function", name)
+	return false
+}
+
+// newBinding retains the callback into a binding scoped by the enclosing KeySwift.when
+func (e *QuickJS) newBinding(fn quickjs.Value) binding {
+	return binding{fn: e.retain(fn), when: slices.Clone(e.scope)}
+}
+
+// scoped returns the bindings whose scopes match the active window and the device of the session
+func (e *QuickJS) scoped(session Bus, bindings []binding) []binding {
+	if !slices.ContainsFunc(bindings, func(b binding) bool { return len(b.when) > 0 || len(b.devices) > 0 }) {
+		return bindings
+	}
+
+	win, device := session.GetActiveWindow(), session.GetDevice()
+	var ret []binding
+	for _, b := range bindings {
+		if b.devices.Match(device) && !slices.ContainsFunc(b.when, func(m windowMatch) bool { return !m.match(win) }) {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+// newWindow converts the window to {title, class}, null if it's nil
+func (e *QuickJS) newWindow(win *Window) quickjs.Value {
+	if win == nil {
+		return e.ctx.Null()
+	}
+	v := e.ctx.Object()
+	v.Set("title", e.ctx.String(win.Title))
+	v.Set("class", e.ctx.String(win.Class))
+	return v
+}
+
+// RunWindowFocus invokes the callbacks of onWindowFocus with the focused window and the previous one
+func (e *QuickJS) RunWindowFocus(session Bus, win, prev *Window) error {
+	return e.do(func() error {
+		if len(e.focusBindings) == 0 {
+			return nil
+		}
+
+		e.session = session
+		defer func() {
+			e.session = nil
+		}()
+
+		winValue, prevValue := e.newWindow(win), e.newWindow(prev)
+		defer winValue.Free()
+		defer prevValue.Free()
+
+		var errs []error
+		for _, fn := range e.focusBindings {
+			if err := e.invoke(fn, winValue, prevValue); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// registerWindow registers KeySwift.onWindowFocus(callback), KeySwift.getActiveWindow()
+// and KeySwift.when({class, title}, fn)
+func (e *QuickJS) registerWindow(ctx *quickjs.Context, keySwift quickjs.Value) {
+	keySwift.Set(FuncGetActiveWindow, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if e.session == nil {
+			slog.Warn("getActiveWindow should be called inside a callback")
+			return ctx.Null()
+		}
+		return e.newWindow(e.session.GetActiveWindow())
+	}))
+
+	keySwift.Set(FuncWhen, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if len(args) != 2 || !args[0].IsObject() || !args[1].IsFunction() {
+			slog.Error("when requires a window match and a function")
+			return ctx.Undefined()
+		}
+
+		m, err := parseWindowMatch(args[0])
+		if err != nil {
+			slog.Error("failed to parse window match", "error", err)
+			return ctx.Undefined()
+		}
+
+		e.scope = append(e.scope, m)
+		defer func() {
+			e.scope = e.scope[:len(e.scope)-1]
+		}()
+		if err := e.invoke(args[1]); err != nil {
+			slog.Error("failed to run when", "error", err)
+		}
+		return ctx.Undefined()
+	}))
+
+	keySwift.Set(FuncOnWindowFocus, ctx.Function(func(ctx *quickjs.Context, this quickjs.Value, args []quickjs.Value) quickjs.Value {
+		if !e.unscoped(FuncOnWindowFocus) {
+			return ctx.Undefined()
+		}
+
+		if len(args) != 1 || !args[0].IsFunction() {
+			slog.Error("onWindowFocus requires a function")
+			return ctx.Undefined()
+		}
+
+		e.focusBindings = append(e.focusBindings, e.retain(args[0]))
+		return ctx.Undefined()
+	}))
+}
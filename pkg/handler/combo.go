@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
+)
+
+var _ stage = (*combo)(nil)
+
+type comboRunner interface {
+	Combos() []engine.Combo
+	RunCombo(combo engine.Combo, device *engine.Device, t time.Time) (bus.Result, error)
+}
+
+// combo resolves the combos registered by KeySwift.onCombo.
+// The press of a key belonging to a combo is buffered together with the following events
+// until the combo is complete, or it's clear that the keys are typed normally.
+// In the later case the buffered events are emitted in their original order.
+type combo struct {
+	runner  comboRunner
+	device  *engine.Device
+	pending *pendingCombo
+	// fired records the keys of the triggered combos whose release events should be dropped
+	fired map[golibevdev.KeyEventCode]struct{}
+}
+
+type pendingCombo struct {
+	start    time.Time
+	buffered []golibevdev.Event
+	pressed  []golibevdev.KeyEventCode
+	// candidates are the combos containing all the pressed keys within their timeout
+	candidates []engine.Combo
+}
+
+func newCombo(runner comboRunner, device *engine.Device) *combo {
+	return &combo{
+		runner: runner,
+		device: device,
+		fired:  make(map[golibevdev.KeyEventCode]struct{}),
+	}
+}
+
+func (c *combo) Handle(ev golibevdev.Event) []golibevdev.Event {
+	code := ev.Code.(golibevdev.KeyEventCode)
+	if c.pending != nil {
+		return c.handlePending(ev, code)
+	}
+
+	if _, ok := c.fired[code]; ok {
+		if ev.Value == KeyReleased {
+			delete(c.fired, code)
+		}
+		return nil
+	}
+
+	if ev.Value != KeyPressed {
+		return []golibevdev.Event{ev}
+	}
+
+	candidates := slices.DeleteFunc(c.runner.Combos(), func(cb engine.Combo) bool {
+		return !slices.Contains(cb.Keys, code) || !cb.Devices.Match(c.device)
+	})
+	if len(candidates) == 0 {
+		return []golibevdev.Event{ev}
+	}
+
+	c.pending = &pendingCombo{
+		start:      time.Now(),
+		buffered:   []golibevdev.Event{ev},
+		pressed:    []golibevdev.KeyEventCode{code},
+		candidates: candidates,
+	}
+	return nil
+}
+
+func (c *combo) handlePending(ev golibevdev.Event, code golibevdev.KeyEventCode) []golibevdev.Event {
+	p := c.pending
+	p.buffered = append(p.buffered, ev)
+
+	if ev.Value != KeyPressed || slices.Contains(p.pressed, code) {
+		// a key is released or repeated before the combo is complete
+		if matched, ok := p.matched(); ok {
+			c.fire(matched)
+			if ev.Value == KeyReleased {
+				delete(c.fired, code)
+			}
+			return nil
+		}
+		return c.flush()
+	}
+
+	now := time.Now()
+	p.pressed = append(p.pressed, code)
+	p.candidates = slices.DeleteFunc(p.candidates, func(cb engine.Combo) bool {
+		return !slices.Contains(cb.Keys, code) || now.Sub(p.start) > cb.Timeout
+	})
+	if len(p.candidates) == 0 {
+		return c.flush()
+	}
+
+	// fire right away unless a longer combo is still possible
+	if matched, ok := p.matched(); ok && len(p.candidates) == 1 {
+		c.fire(matched)
+	}
+	return nil
+}
+
+// matched returns the candidate made of exactly the pressed keys
+func (p *pendingCombo) matched() (engine.Combo, bool) {
+	for _, cb := range p.candidates {
+		if len(cb.Keys) == len(p.pressed) {
+			return cb, true
+		}
+	}
+	return engine.Combo{}, false
+}
+
+// fire invokes the combo and drops the buffered events,
+// the release events of the combo keys are dropped later
+func (c *combo) fire(cb engine.Combo) {
+	p := c.pending
+	c.pending = nil
+
+	for _, code := range p.pressed {
+		c.fired[code] = struct{}{}
+	}
+
+	if _, err := c.runner.RunCombo(cb, c.device, p.buffered[0].Time); err != nil {
+		slog.Error("Error running combo", "error", err)
+	}
+}
+
+// flush emits the buffered events as typed
+func (c *combo) flush() []golibevdev.Event {
+	p := c.pending
+	c.pending = nil
+	return p.buffered
+}
+
+func (c *combo) Deadline() time.Time {
+	if c.pending == nil {
+		return time.Time{}
+	}
+	var longest time.Duration
+	for _, cb := range c.pending.candidates {
+		longest = max(longest, cb.Timeout)
+	}
+	return c.pending.start.Add(longest)
+}
+
+func (c *combo) Expire(time.Time) []golibevdev.Event {
+	if c.pending == nil {
+		return nil
+	}
+	if matched, ok := c.pending.matched(); ok {
+		c.fire(matched)
+		return nil
+	}
+	return c.flush()
+}
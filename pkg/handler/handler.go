@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -10,13 +11,19 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
 )
 
 const (
 	KeyPressed  = 1
 	KeyReleased = 0
+	KeyRepeated = 2
 )
 
+// maskKey is tapped to break the lone tap of a modifier, it isn't bound to anything
+const maskKey = golibevdev.KeyUnknown
+
 // InputDevice represents a grabbed input device
 type InputDevice struct {
 	Device *golibevdev.InputDev
@@ -29,7 +36,7 @@ type Handler struct {
 	devices []*InputDevice
 	wg      sync.WaitGroup
 
-	out *golibevdev.UInputDev
+	out bus.EventWriter
 }
 
 // New creates a new input device handler
@@ -65,7 +72,7 @@ func (m *Handler) AddDevice(name, path string) error {
 }
 
 // ProcessEvents starts processing events from all devices
-func (m *Handler) ProcessEvents(virtualKeyboard *golibevdev.UInputDev, modeManager *bus.Impl) {
+func (m *Handler) ProcessEvents(virtualKeyboard bus.EventWriter, modeManager *bus.Impl) {
 	m.out = virtualKeyboard
 	for _, dev := range m.devices {
 		m.wg.Add(1)
@@ -81,177 +88,539 @@ type KeyState struct {
 	Time time.Time
 }
 
+// readEvents reads the events of a device in the background
+func (m *Handler) readEvents(dev *InputDevice) <-chan golibevdev.Event {
+	events := make(chan golibevdev.Event, 64)
+	go func() {
+		defer close(events)
+		for {
+			ev, err := dev.Device.NextEvent(golibevdev.ReadFlagNormal)
+			if err != nil {
+				slog.Error("Error reading from device", "device", dev.Name, "error", err)
+				return
+			}
+
+			slog.Debug("event", "code", ev.Code, "value", ev.Value, "time", ev.Time.UnixMicro())
+			events <- ev
+		}
+	}()
+	return events
+}
+
 // processDeviceEvents processes events from a single device
 func (m *Handler) processDeviceEvents(dev *InputDevice, modeManager *bus.Impl) {
 	slog.Info("Starting event processing for device", "device", dev.Name)
-	mu := sync.Mutex{}
 
-	var (
-		keyStates       = make(map[golibevdev.KeyEventCode]KeyState)
-		eventStack      []golibevdev.Event
-		modifier        = NewModifier()
-		passThroughKeys = make(map[golibevdev.KeyEventCode]struct{})
-		byPassKeys      = make(map[golibevdev.KeyEventCode]struct{})
+	events := m.readEvents(dev)
+	state := newDeviceState(m, modeManager, &engine.Device{Name: dev.Name, Path: dev.Path})
+	p := state.pipeline()
 
-		lastKeyIsModifier  = false
-		lastEventIsRelease = false
-	)
+	for {
+		var wake <-chan time.Time
+		if deadline := earliest(p.Deadline(), state.Deadline()); !deadline.IsZero() {
+			wake = time.After(time.Until(deadline))
+		}
+
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			// sync events are generated by the pipeline after each key event
+			if ev.Type != golibevdev.EvKey {
+				continue
+			}
+			modeManager.RecordInput(ev.Code.(golibevdev.KeyEventCode), ev.Value)
+			p.Feed(ev)
+		case now := <-wake:
+			p.Expire(now)
+			state.Expire(now)
+		}
+	}
+}
+
+// earliest returns the earliest non-zero time
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
 
-	// modifier key(here we only consider ctrl, alt) always pass through
+// deviceState holds the chord matching state of a device
+type deviceState struct {
+	m           *Handler
+	modeManager *bus.Impl
+	device      *engine.Device
+
+	keyStates map[golibevdev.KeyEventCode]KeyState
+	// pressOrder is the keys of keyStates in press order
+	pressOrder      []golibevdev.KeyEventCode
+	eventStack      []golibevdev.Event
+	modifier        *Modifier
+	passThroughKeys map[golibevdev.KeyEventCode]struct{}
+	byPassKeys      map[golibevdev.KeyEventCode]struct{}
+
+	// policies are the policies of the modifiers when they're pressed, their releases follow them
+	// even if the active window changes in between
+	policies map[golibevdev.KeyEventCode]engine.ModifierPolicy
+
+	lastKey            golibevdev.KeyEventCode
+	lastKeyIsModifier  bool
+	lastEventIsRelease bool
+
+	// sequence holds the events of the pending key sequence
+	sequence *pendingSequence
+	// repeat repeats the keys sent for the held chord
+	repeat *autoRepeat
+}
+
+type pendingSequence struct {
+	events   []golibevdev.Event
+	deadline time.Time
+}
+
+func newDeviceState(m *Handler, modeManager *bus.Impl, device *engine.Device) *deviceState {
+	s := &deviceState{
+		m:               m,
+		modeManager:     modeManager,
+		device:          device,
+		keyStates:       make(map[golibevdev.KeyEventCode]KeyState),
+		modifier:        NewModifier(),
+		passThroughKeys: make(map[golibevdev.KeyEventCode]struct{}),
+		byPassKeys:      make(map[golibevdev.KeyEventCode]struct{}),
+		policies:        make(map[golibevdev.KeyEventCode]engine.ModifierPolicy),
+	}
+
+	// the eager modifier keys (ctrl and alt by default, see setModifierPolicy) always pass through
 	// when modifier key + other key hit the rules
 	// we simulate the related modifier key release event to output device
 	// e.g. When ctrl pressed, we send the press event of ctrl to output device
 	// and then if c pressed, and ctrl+c hit the rules, we send the release event of ctrl to output device
 	// this is useful for the scenario like holding ctrl and click mouse in browser to open new tab
 
-	modeManager.SetBeforeSendKeysPerSession(func() {
-		if len(passThroughKeys) == 0 {
+	modeManager.SetBeforeSendKeys(device, func() {
+		if len(s.passThroughKeys) == 0 {
 			return
 		}
 
-		for key := range passThroughKeys {
+		for key := range s.passThroughKeys {
 			_ = m.out.WriteEvent(golibevdev.EvKey, key, 0)
 		}
 		_ = m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
 
-		passThroughKeys = make(map[golibevdev.KeyEventCode]struct{})
+		s.passThroughKeys = make(map[golibevdev.KeyEventCode]struct{})
 	})
 
-	for {
-		ev, err := dev.Device.NextEvent(golibevdev.ReadFlagNormal)
-		if err != nil {
-			slog.Error("Error reading from device", "device", dev.Name, "error", err)
-			return
-		}
+	return s
+}
 
-		slog.Debug("event", "code", ev.Code, "value", ev.Value, "time", ev.Time.UnixMicro())
+// pipeline returns the stages the key events of the device go through before process
+func (s *deviceState) pipeline() *pipeline {
+	return newPipeline(s.process,
+		newTapHold(s.modeManager),
+		newLayer(s.modeManager),
+		newCombo(s.modeManager, s.device),
+	)
+}
 
-		// Handle sync events
-		if ev.Type == golibevdev.EvSyn {
-			if len(keyStates) == 0 && len(passThroughKeys) > 0 {
-				passThroughKeys = make(map[golibevdev.KeyEventCode]struct{})
-			}
-			eventStack = append(eventStack, ev)
-			// Process any pending events in the stack
-			forceNoPassThrough := lastKeyIsModifier && !lastEventIsRelease
-			handled := m.processEventStack(eventStack, keyStates, modeManager, forceNoPassThrough)
-			if !forceNoPassThrough {
-				eventStack = eventStack[:0]
-			}
-			if handled {
-				byPassKeys = make(map[golibevdev.KeyEventCode]struct{})
-				for key := range keyStates {
-					byPassKeys[key] = struct{}{}
-				}
-				continue
-			}
-			for key := range keyStates {
-				_, ok := passThroughKeys[key]
-				if !ok && modifier.ShouldPassThrough(key) {
-					passThroughKeys[key] = struct{}{}
-					m.sendSingleKey(key, KeyPressed)
-				}
-			}
-			continue
-		}
+// process handles a key event coming out of the pipeline, followed by a sync event
+func (s *deviceState) process(ev golibevdev.Event) {
+	if !s.handleKey(ev) {
+		return
+	}
+	s.handleSync(golibevdev.Event{
+		Type: golibevdev.EvSyn,
+		Code: golibevdev.SynReport,
+		Time: ev.Time,
+	})
+}
 
-		// Handle key events
-		if ev.Type == golibevdev.EvKey {
-			if ev.Value != KeyPressed && ev.Value != KeyReleased {
-				continue
+// handleKey updates the key states, returns false if the event is dropped
+func (s *deviceState) handleKey(ev golibevdev.Event) bool {
+	if ev.Value == KeyRepeated {
+		// the keys forwarded are repeated by the system and the keys sent by the callbacks by autoRepeat,
+		// only the callbacks asking for it are notified
+		if len(s.keyStates) > 0 {
+			result := s.dispatch(&bus.KeyPressEvent{
+				Keys:     s.pressedKeys(),
+				Pressed:  true,
+				Repeated: true,
+				Time:     ev.Time,
+				Device:   s.device,
+			})
+			if result.Handled {
+				// the callback repeats the keys by itself
+				s.stopRepeat()
 			}
-
-			keyCode := ev.Code.(golibevdev.KeyEventCode)
-			isModifier := modifier.IsModifier(keyCode)
-			lastKeyIsModifier = isModifier
-			lastEventIsRelease = ev.Value == KeyReleased
-
-			// Update key state
-			mu.Lock()
-			if ev.Value == KeyPressed {
-				keyStates[keyCode] = KeyState{
-					Time: ev.Time,
-				}
-				if isModifier {
-					modifier.Press(keyCode)
-				}
-			} else {
-				delete(keyStates, keyCode)
-				if isModifier {
-					modifier.Release(keyCode)
-				}
+		}
+		return false
+	}
+	if ev.Value != KeyPressed && ev.Value != KeyReleased {
+		return false
+	}
+	// any other key stops the repeat, like the keyboard does
+	s.stopRepeat()
+
+	keyCode := ev.Code.(golibevdev.KeyEventCode)
+	if ev.Value == KeyReleased {
+		// the chord being released is the keys pressed before this event
+		if _, ok := s.keyStates[keyCode]; ok {
+			s.dispatch(&bus.KeyPressEvent{
+				Keys:    s.pressedKeys(),
+				Pressed: false,
+				Time:    ev.Time,
+				Device:  s.device,
+			})
+		}
+	}
+	isModifier := s.modifier.IsModifier(keyCode)
+	s.lastKey = keyCode
+	s.lastKeyIsModifier = isModifier
+	s.lastEventIsRelease = ev.Value == KeyReleased
+
+	// Update key state
+	if ev.Value == KeyPressed {
+		if _, ok := s.keyStates[keyCode]; !ok {
+			s.pressOrder = append(s.pressOrder, keyCode)
+		}
+		s.keyStates[keyCode] = KeyState{
+			Time: ev.Time,
+		}
+		s.modifier.Interrupt()
+		if isModifier {
+			s.modifier.Press(keyCode)
+			s.policies[keyCode] = s.modeManager.ModifierPolicy(keyCode)
+		}
+	} else {
+		pressed := s.keyStates[keyCode]
+		delete(s.keyStates, keyCode)
+		s.pressOrder = slices.DeleteFunc(s.pressOrder, func(code golibevdev.KeyEventCode) bool {
+			return code == keyCode
+		})
+		if isModifier {
+			tapped := s.modifier.IsTapped(keyCode)
+			s.modifier.Release(keyCode)
+			if tapped && len(s.keyStates) == 0 && s.tapModifier(keyCode, ev.Time.Sub(pressed.Time), ev.Time) {
+				return false
 			}
-			mu.Unlock()
-
-			if ev.Value == KeyReleased {
-				k := ev.Code.(golibevdev.KeyEventCode)
-				if _, ok := byPassKeys[k]; ok {
-					slog.Debug("drop key release event", "key", k.String())
-					delete(byPassKeys, k)
-					continue
-				}
+			if tapped && s.modeManager.IsStickyModifier(keyCode) {
+				s.modifier.Tap(keyCode)
+				slog.Debug("sticky modifiers", "keys", s.modifier.Sticky())
 			}
+		}
+	}
 
-			// Add event to stack
-			eventStack = append(eventStack, ev)
+	if ev.Value == KeyReleased {
+		if _, ok := s.byPassKeys[keyCode]; ok {
+			slog.Debug("drop key release event", "key", keyCode.String())
+			delete(s.byPassKeys, keyCode)
+			return false
 		}
 	}
+
+	// Add event to stack
+	s.eventStack = append(s.eventStack, ev)
+	return true
 }
 
-// processEventStack processes a stack of events and determines if they should be handled
-// return true if the events should be handled, false if the events should be forwarded
-func (m *Handler) processEventStack(
-	events []golibevdev.Event,
-	keyStates map[golibevdev.KeyEventCode]KeyState,
-	modeManager *bus.Impl,
-	forceNoPassThrough bool,
-) bool {
-	// Get currently pressed keys
-	pressedKeys := lo.Keys(keyStates)
+// tapModifier handles a modifier tapped alone and held for the duration, returns true if the tap is consumed
+func (s *deviceState) tapModifier(key golibevdev.KeyEventCode, held time.Duration, t time.Time) bool {
+	if _, ok := s.byPassKeys[key]; ok || s.sequence != nil {
+		return false
+	}
 
-	if len(pressedKeys) == 0 {
-		// No keys are pressed, just forward all events
-		for _, ev := range events {
-			_ = m.out.WriteEvent(ev.Type, ev.Code, ev.Value)
-		}
-		slog.Debug("forward all events", "events", events)
+	tap, ok := s.modeManager.ModifierTap(key)
+	ok = ok && held <= tap.Timeout
+	if !ok && !s.modeManager.IsTapSuppressed(key) {
 		return false
 	}
 
-	// Create event for bus processing
-	event := &bus.Event{
-		KeyPress: &bus.KeyPressEvent{
-			Keys:    pressedKeys,
-			Pressed: true,
-		},
+	// the press held back is dropped, the press passed through is masked before its release
+	s.eventStack = s.eventStack[:0]
+	s.maskPassThrough(key)
+	if ok {
+		if _, err := s.modeManager.RunModifierTap(tap, s.device, t); err != nil {
+			slog.Error("Error running modifier tap", "error", err)
+		}
+	}
+	return true
+}
+
+// maskPassThrough releases a modifier passed through after tapping maskKey,
+// so the system doesn't take it as tapped alone, e.g. GNOME opens the overview on a lone Super tap
+func (s *deviceState) maskPassThrough(key golibevdev.KeyEventCode) {
+	if _, ok := s.passThroughKeys[key]; !ok {
+		return
 	}
+	delete(s.passThroughKeys, key)
+	s.m.sendSingleKey(maskKey, KeyPressed)
+	s.m.sendSingleKey(maskKey, KeyReleased)
+	s.m.sendSingleKey(key, KeyReleased)
+}
 
-	// Process through bus manager
-	handled, err := modeManager.ProcessEvent(event)
+// dispatch notifies the callbacks of a release or repeat event,
+// these events are forwarded regardless of the callbacks
+func (s *deviceState) dispatch(keyPress *bus.KeyPressEvent) bus.Result {
+	result, err := s.modeManager.ProcessEvent(&bus.Event{KeyPress: keyPress})
 	if err != nil {
 		slog.Error("Error processing event", "error", err)
-		return false
 	}
+	return result
+}
 
-	if handled {
+// handleSync processes the pending events in the stack
+func (s *deviceState) handleSync(ev golibevdev.Event) {
+	if len(s.keyStates) == 0 && len(s.passThroughKeys) > 0 {
+		s.passThroughKeys = make(map[golibevdev.KeyEventCode]struct{})
+	}
+	s.eventStack = append(s.eventStack, ev)
+
+	// Create event for bus processing, releases are dispatched in handleKey
+	var event *bus.Event
+	if len(s.keyStates) > 0 && !s.lastEventIsRelease {
+		event = &bus.Event{
+			KeyPress: &bus.KeyPressEvent{
+				Keys:    s.pressedKeys(),
+				Pressed: true,
+				Time:    ev.Time,
+				Device:  s.device,
+			},
+		}
+	}
+
+	// Process any pending events in the stack
+	forceNoPassThrough := s.lastKeyIsModifier && !s.lastEventIsRelease
+	result := s.processEventStack(event, forceNoPassThrough)
+	if !forceNoPassThrough {
+		s.eventStack = s.eventStack[:0]
+	}
+	if !s.lastKeyIsModifier && !s.lastEventIsRelease {
+		s.recordTyped(result)
+		// the armed modifiers are applied to this key, by the callbacks or by forwarding
+		s.modifier.Disarm()
+	}
+	if result.Handled {
+		if !s.lastKeyIsModifier && !s.lastEventIsRelease {
+			s.startRepeat(result.Repeat)
+		}
+		s.bypassPressedKeys()
+		return
+	}
+	if s.sequence != nil {
+		// modifiers are not passed through while a key sequence is pending,
+		// they are replayed in order if the sequence is aborted
+		return
+	}
+	for key := range s.keyStates {
+		_, ok := s.passThroughKeys[key]
+		if !ok && s.isEager(key) {
+			s.passThroughKeys[key] = struct{}{}
+			s.m.sendSingleKey(key, KeyPressed)
+		}
+	}
+}
+
+// recordTyped records the key typed through for the hotstrings
+func (s *deviceState) recordTyped(result bus.Result) {
+	if result.Handled || s.sequence != nil {
+		s.modeManager.ResetTyped()
+		return
+	}
+
+	var shift bool
+	for _, code := range s.pressedKeys() {
+		switch code {
+		case golibevdev.KeyLeftShift, golibevdev.KeyRightShift:
+			shift = true
+		default:
+			if s.modifier.IsModifier(code) {
+				// a shortcut
+				s.modeManager.ResetTyped()
+				return
+			}
+		}
+	}
+	if s.modeManager.Typed(s.device, s.lastKey, shift) {
+		// the key is released by the expansion
+		s.byPassKeys[s.lastKey] = struct{}{}
+	}
+}
+
+// pressedKeys returns the sticky modifiers followed by the keys pressed in press order
+func (s *deviceState) pressedKeys() []keys.Key {
+	return lo.Union(s.modifier.Sticky(), s.pressOrder)
+}
+
+// bypassPressedKeys drops the release events of the keys currently pressed
+func (s *deviceState) bypassPressedKeys() {
+	s.byPassKeys = make(map[golibevdev.KeyEventCode]struct{})
+	for key := range s.keyStates {
+		s.byPassKeys[key] = struct{}{}
+	}
+}
+
+// processEventStack processes the stack of events and determines if they should be handled.
+// The events are forwarded unless they are handled or held back by a key sequence.
+func (s *deviceState) processEventStack(event *bus.Event, forceNoPassThrough bool) bus.Result {
+	if event == nil && s.sequence == nil {
+		// Nothing to dispatch, just forward all events
+		s.forward(s.eventStack)
+		slog.Debug("forward all events", "events", s.eventStack)
+		return bus.Result{}
+	}
+
+	var result bus.Result
+	if event != nil {
+		// Process through bus manager
+		var err error
+		result, err = s.modeManager.ProcessEvent(event)
+		if err != nil {
+			slog.Error("Error processing event", "error", err)
+			return bus.Result{}
+		}
+	}
+
+	if result.Aborted {
+		s.replaySequence()
+	}
+
+	if result.Handled {
 		// If handled, we don't forward the events
-		return true
+		s.sequence = nil
+		return result
+	}
+
+	if result.Pending || s.sequence != nil {
+		s.holdSequence(result)
+		return result
 	}
 
 	if forceNoPassThrough {
-		slog.Debug("force no pass through", "events", events)
-		return false
+		slog.Debug("force no pass through", "events", s.eventStack)
+		return result
 	}
 
 	// If not handled, forward all events in order
+	s.forward(s.eventStack)
+	return result
+}
+
+// isEager returns true if the modifier is forwarded as soon as it's pressed
+func (s *deviceState) isEager(key golibevdev.KeyEventCode) bool {
+	return s.modifier.IsModifier(key) && s.policy(key) == engine.ModifierEager
+}
+
+// policy returns the policy of the modifier when it was pressed,
+// or the one of the active window if it was pressed before KeySwift started
+func (s *deviceState) policy(key golibevdev.KeyEventCode) engine.ModifierPolicy {
+	if policy, ok := s.policies[key]; ok {
+		return policy
+	}
+	return s.modeManager.ModifierPolicy(key)
+}
+
+func (s *deviceState) forward(events []golibevdev.Event) {
 	for _, ev := range events {
 		if ev.Type == golibevdev.EvKey {
-			slog.Debug("Forwarding key event", "key", ev.Code.(golibevdev.KeyEventCode).String(), "pressed", ev.Value)
+			code := ev.Code.(golibevdev.KeyEventCode)
+			if s.modifier.IsModifier(code) && s.policy(code) == engine.ModifierNever {
+				continue
+			}
+			slog.Debug("Forwarding key event", "key", code.String(), "pressed", ev.Value)
+			if ev.Value == KeyPressed && !s.modifier.IsModifier(code) {
+				s.forwardSticky(ev)
+				continue
+			}
+		}
+		_ = s.m.out.WriteEvent(ev.Type, ev.Code, ev.Value)
+	}
+}
+
+// forwardSticky forwards a key press wrapped by the press and release of the sticky modifiers
+func (s *deviceState) forwardSticky(ev golibevdev.Event) {
+	sticky := lo.Filter(s.modifier.Sticky(), func(code golibevdev.KeyEventCode, _ int) bool {
+		_, pressed := s.keyStates[code]
+		return !pressed
+	})
+	if len(sticky) == 0 {
+		_ = s.m.out.WriteEvent(ev.Type, ev.Code, ev.Value)
+		return
+	}
+
+	for _, code := range sticky {
+		_ = s.m.out.WriteEvent(golibevdev.EvKey, code, KeyPressed)
+	}
+	_ = s.m.out.WriteEvent(ev.Type, ev.Code, ev.Value)
+	_ = s.m.out.WriteEvent(golibevdev.EvSyn, golibevdev.SynReport, 0)
+	for _, code := range sticky {
+		_ = s.m.out.WriteEvent(golibevdev.EvKey, code, KeyReleased)
+	}
+}
+
+// holdSequence moves the events in the stack to the pending key sequence
+func (s *deviceState) holdSequence(result bus.Result) {
+	if s.sequence == nil {
+		s.sequence = &pendingSequence{}
+	}
+	if result.Pending {
+		s.sequence.deadline = time.Now().Add(result.Timeout)
+	}
+	s.sequence.events = append(s.sequence.events, s.eventStack...)
+	s.eventStack = s.eventStack[:0]
+}
+
+// replaySequence forwards the events held back by the aborted key sequence
+func (s *deviceState) replaySequence() {
+	seq := s.sequence
+	s.sequence = nil
+	if seq == nil {
+		return
+	}
+
+	slog.Debug("replay sequence", "events", seq.events)
+	s.forward(seq.events)
+	// the replayed modifiers still pressed are passed through now
+	for _, ev := range seq.events {
+		if ev.Type != golibevdev.EvKey {
+			continue
 		}
-		_ = m.out.WriteEvent(ev.Type, ev.Code, ev.Value)
+		key := ev.Code.(golibevdev.KeyEventCode)
+		if _, ok := s.keyStates[key]; ok && s.isEager(key) {
+			s.passThroughKeys[key] = struct{}{}
+		}
+	}
+}
+
+// Deadline returns the timeout of the pending key sequence or the next key repeat
+func (s *deviceState) Deadline() time.Time {
+	var deadline time.Time
+	if s.sequence != nil {
+		deadline = s.sequence.deadline
+	}
+	if s.repeat != nil {
+		deadline = earliest(deadline, s.repeat.next)
 	}
+	return deadline
+}
 
-	return false
+// Expire ends the pending key sequence after its timeout and repeats the keys sent for the held chord
+func (s *deviceState) Expire(now time.Time) {
+	s.expireRepeat(now)
+	if s.sequence == nil || now.Before(s.sequence.deadline) {
+		return
+	}
+
+	result, err := s.modeManager.ExpireSequence(s.device)
+	if err != nil {
+		slog.Error("Error expiring sequence", "error", err)
+	}
+	if result.Handled {
+		s.sequence = nil
+		s.bypassPressedKeys()
+		return
+	}
+	s.replaySequence()
 }
 
 func (m *Handler) sendSingleKey(code golibevdev.KeyEventCode, value int32) {
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+	"github.com/jialeicui/keyswift/pkg/wininfo"
+)
+
+// virtualKeyboard records the key events written to it like "leftctrl:1" as the kernel passes them on,
+// the press of a pressed key and the release of a released key are dropped, the sync events are left out
+type virtualKeyboard struct {
+	mu      sync.Mutex
+	pressed map[keys.Key]bool
+	events  []string
+}
+
+func (k *virtualKeyboard) WriteEvent(evType golibevdev.EventType, code golibevdev.EventCode, value int32) error {
+	if evType != golibevdev.EvKey {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key := code.(keys.Key)
+	if value != KeyRepeated && k.pressed[key] == (value == KeyPressed) {
+		return nil
+	}
+	if k.pressed == nil {
+		k.pressed = map[keys.Key]bool{}
+	}
+	k.pressed[key] = value != KeyReleased
+	k.events = append(k.events, fmt.Sprintf("%s:%d", keys.Name(key), value))
+	return nil
+}
+
+func (k *virtualKeyboard) take() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	events := k.events
+	k.events = nil
+	return events
+}
+
+// fakeWindows lets the tests change the active window
+type fakeWindows struct {
+	onChange wininfo.ActiveWindowChangeCallback
+}
+
+func (w *fakeWindows) GetActiveWindow() (*wininfo.WinInfo, error) {
+	return nil, nil
+}
+
+func (w *fakeWindows) OnActiveWindowChange(cb wininfo.ActiveWindowChangeCallback) error {
+	w.onChange = cb
+	return nil
+}
+
+func (w *fakeWindows) Close() {}
+
+func (w *fakeWindows) focus(class string) {
+	w.onChange(&wininfo.WinInfo{Class: class})
+}
+
+// testKeyboard feeds the key events of a keyboard through the handler into a bus running the script
+type testKeyboard struct {
+	t       *testing.T
+	out     *virtualKeyboard
+	windows *fakeWindows
+	state   *deviceState
+	p       *pipeline
+}
+
+func newTestKeyboard(t *testing.T, script string) *testKeyboard {
+	out := &virtualKeyboard{}
+	windows := &fakeWindows{}
+	modeManager, err := bus.New(script, windows, out)
+	require.NoError(t, err)
+
+	state := newDeviceState(&Handler{out: out}, modeManager, &engine.Device{Name: "test keyboard"})
+	return &testKeyboard{
+		t:       t,
+		out:     out,
+		windows: windows,
+		state:   state,
+		p:       state.pipeline(),
+	}
+}
+
+// another returns a keyboard of the same bus and virtual keyboard
+func (k *testKeyboard) another(name string) *testKeyboard {
+	state := newDeviceState(k.state.m, k.state.modeManager, &engine.Device{Name: name})
+	return &testKeyboard{
+		t:       k.t,
+		out:     k.out,
+		windows: k.windows,
+		state:   state,
+		p:       state.pipeline(),
+	}
+}
+
+// press feeds the press events of the keys in order
+func (k *testKeyboard) press(names ...string) {
+	for _, name := range names {
+		k.p.Feed(keyEvent(key(k.t, name), KeyPressed, time.Now()))
+	}
+}
+
+// release feeds the release events of the keys in order
+func (k *testKeyboard) release(names ...string) {
+	for _, name := range names {
+		k.p.Feed(keyEvent(key(k.t, name), KeyReleased, time.Now()))
+	}
+}
+
+// tap presses and releases each key in order
+func (k *testKeyboard) tap(names ...string) {
+	for _, name := range names {
+		k.press(name)
+		k.release(name)
+	}
+}
+
+// expire wakes up the handler at its next deadline
+func (k *testKeyboard) expire() {
+	deadline := earliest(k.p.Deadline(), k.state.Deadline())
+	require.False(k.t, deadline.IsZero(), "nothing is pending")
+	k.p.Expire(deadline)
+	k.state.Expire(deadline)
+}
+
+// expect checks the events written to the virtual keyboard since the last check
+func (k *testKeyboard) expect(events ...string) {
+	k.t.Helper()
+	if len(events) == 0 {
+		events = nil
+	}
+	require.Equal(k.t, events, k.out.take())
+}
+
+func TestHandlerSequence(t *testing.T) {
+	k := newTestKeyboard(t, `KeySwift.onSequence(["ctrl+k", "ctrl+c"], () => KeySwift.sendKeys(["f1"]));`)
+
+	// ctrl passes through until the first step matches, the steps are held back
+	k.press("leftctrl")
+	k.expect("leftctrl:1")
+	k.tap("k")
+	k.expect()
+	k.press("c")
+	k.expect("leftctrl:0", "f1:1", "f1:0")
+	k.release("c", "leftctrl")
+	k.expect()
+
+	// a key out of the sequence replays the keys held back
+	k.press("leftctrl")
+	k.tap("k")
+	k.press("x")
+	k.expect("leftctrl:1", "k:1", "k:0", "x:1")
+	k.release("x", "leftctrl")
+	k.expect("x:0", "leftctrl:0")
+
+	// and so does the timeout
+	k.press("leftctrl")
+	k.tap("k")
+	k.release("leftctrl")
+	k.expect("leftctrl:1")
+	k.expire()
+	k.expect("k:1", "k:0", "leftctrl:0")
+}
+
+func TestHandlerStickyModifiers(t *testing.T) {
+	k := newTestKeyboard(t, `
+KeySwift.setStickyModifiers(["shift"]);
+KeySwift.onKeyPress(["shift", "f2"], () => KeySwift.sendKeys(["f3"]));
+`)
+
+	// a tap arms the modifier for the next key
+	k.tap("rightshift")
+	k.expect("rightshift:1", "rightshift:0")
+	k.tap("a")
+	k.expect("rightshift:1", "a:1", "rightshift:0", "a:0")
+	k.tap("b")
+	k.expect("b:1", "b:0")
+
+	// the armed modifier is part of the chord passed to the callbacks
+	k.tap("leftshift", "f2")
+	k.expect("leftshift:1", "leftshift:0", "f3:1", "f3:0")
+
+	// a double tap locks it until it's tapped again
+	k.tap("leftshift", "leftshift", "a", "b")
+	k.expect(
+		"leftshift:1", "leftshift:0", "leftshift:1", "leftshift:0",
+		"leftshift:1", "a:1", "leftshift:0", "a:0",
+		"leftshift:1", "b:1", "leftshift:0", "b:0",
+	)
+	k.tap("leftshift", "c")
+	k.expect("leftshift:1", "leftshift:0", "c:1", "c:0")
+}
+
+func TestHandlerKeyRepeat(t *testing.T) {
+	k := newTestKeyboard(t, `KeySwift.onKeyPress(["ctrl", "b"], () => KeySwift.sendKeys(["left"]));`)
+
+	k.press("leftctrl", "b")
+	k.expect("leftctrl:1", "leftctrl:0", "left:1", "left:0")
+	// the chord is held after the delay and repeated like a keyboard does
+	k.expire()
+	k.expect("left:1")
+	k.expire()
+	k.expire()
+	k.expect("left:2", "left:2")
+	k.release("b")
+	k.expect("left:0")
+	k.release("leftctrl")
+	k.expect()
+	require.True(t, k.state.Deadline().IsZero())
+
+	k = newTestKeyboard(t, `
+KeySwift.onKeyPress(["ctrl", "b"], () => KeySwift.sendKeys(["left"]));
+KeySwift.setKeyRepeat(false);
+`)
+	k.press("leftctrl", "b")
+	k.expect("leftctrl:1", "leftctrl:0", "left:1", "left:0")
+	require.True(t, k.state.Deadline().IsZero())
+}
+
+func TestHandlerModifierTap(t *testing.T) {
+	k := newTestKeyboard(t, `
+KeySwift.suppressModifierTaps(["meta"]);
+KeySwift.onModifierTap("l-alt", () => KeySwift.sendKeys(["f1"]));
+`)
+
+	// the suppressed tap is dropped, the modifier still works in chords
+	k.tap("leftmeta")
+	k.expect()
+	k.press("leftmeta")
+	k.tap("e")
+	k.release("leftmeta")
+	k.expect("leftmeta:1", "e:1", "e:0", "leftmeta:0")
+
+	// alt is passed through on press, so its tap is masked before the callback runs
+	k.tap("leftalt")
+	k.expect("leftalt:1", "unknown:1", "unknown:0", "leftalt:0", "f1:1", "f1:0")
+
+	k.tap("leftctrl")
+	k.expect("leftctrl:1", "leftctrl:0")
+}
+
+func TestHandlerModifierPolicy(t *testing.T) {
+	k := newTestKeyboard(t, `
+KeySwift.setModifierPolicy(["ctrl"], "lazy");
+KeySwift.setModifierPolicy(["alt"], "never", {windowClass: ["kitty"]});
+KeySwift.onKeyPress(["ctrl", "j"], () => KeySwift.sendKeys(["down"]));
+`)
+
+	// a lazy modifier is forwarded with the next key
+	k.press("leftctrl")
+	k.expect()
+	k.tap("c")
+	k.expect("leftctrl:1", "c:1", "c:0")
+	k.release("leftctrl")
+	k.expect("leftctrl:0")
+	// and never if the chord is handled
+	k.press("leftctrl", "j")
+	k.release("j", "leftctrl")
+	k.expect("down:1", "down:0")
+
+	// alt is eager except in kitty, where it's never forwarded
+	k.press("leftalt")
+	k.expect("leftalt:1")
+	k.tap("x")
+	k.release("leftalt")
+	k.expect("x:1", "x:0", "leftalt:0")
+	k.windows.focus("kitty")
+	k.press("leftalt")
+	k.tap("x")
+	k.release("leftalt")
+	k.expect("x:1", "x:0")
+
+	// the release follows the policy of the press when the focus changes in between
+	k.windows.focus("firefox")
+	k.press("leftalt")
+	k.windows.focus("kitty")
+	k.tap("x")
+	k.release("leftalt")
+	k.expect("leftalt:1", "x:1", "x:0", "leftalt:0")
+
+	k.press("leftalt")
+	k.windows.focus("firefox")
+	k.tap("x")
+	k.release("leftalt")
+	k.expect("x:1", "x:0")
+}
+
+func TestHandlerDevices(t *testing.T) {
+	laptop := newTestKeyboard(t, `
+KeySwift.onKeyPress(["ctrl", "j"], () => KeySwift.sendKeys(["down"]));
+KeySwift.onSequence(["ctrl+k", "ctrl+c"], () => KeySwift.sendKeys(["f1"]));
+`)
+	hhkb := laptop.another("hhkb")
+
+	// the modifier passed through by a keyboard is released by its own chord
+	laptop.press("leftctrl")
+	laptop.expect("leftctrl:1")
+	hhkb.tap("a")
+	hhkb.expect("a:1", "a:0")
+	laptop.press("j")
+	laptop.expect("leftctrl:0", "down:1", "down:0")
+	laptop.release("j", "leftctrl")
+	laptop.expect()
+
+	// the keyboards type their sequences apart
+	laptop.press("leftctrl")
+	laptop.tap("k")
+	hhkb.press("leftctrl")
+	hhkb.tap("k")
+	hhkb.press("c")
+	laptop.expect("leftctrl:1", "leftctrl:0", "f1:1", "f1:0")
+	hhkb.release("c", "leftctrl")
+	laptop.press("c")
+	laptop.expect("f1:1", "f1:0")
+}
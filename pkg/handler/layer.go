@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+var _ stage = (*layer)(nil)
+
+type layerManager interface {
+	LayerKey(key keys.Key) (engine.LayerKey, bool)
+	ActivateLayer(name string, mode engine.LayerMode)
+	DeactivateLayer(name string)
+	MapKey(key keys.Key) ([]keys.Key, bool)
+}
+
+// layer activates the layers by their keys and remaps the keys of the active layers
+type layer struct {
+	layers layerManager
+	// activators records the pressed layer keys
+	activators map[golibevdev.KeyEventCode]engine.LayerKey
+	// remapped maps the pressed physical keys to the keys they are sent as,
+	// so the release matches the press even if the layer is gone in between
+	remapped map[golibevdev.KeyEventCode][]keys.Key
+}
+
+func newLayer(layers layerManager) *layer {
+	return &layer{
+		layers:     layers,
+		activators: make(map[golibevdev.KeyEventCode]engine.LayerKey),
+		remapped:   make(map[golibevdev.KeyEventCode][]keys.Key),
+	}
+}
+
+func (l *layer) Handle(ev golibevdev.Event) []golibevdev.Event {
+	code := ev.Code.(golibevdev.KeyEventCode)
+
+	if lk, ok := l.activators[code]; ok {
+		if ev.Value == KeyReleased {
+			delete(l.activators, code)
+			if lk.Mode == engine.LayerMomentary {
+				l.layers.DeactivateLayer(lk.Layer)
+			}
+		}
+		return nil
+	}
+
+	if to, ok := l.remapped[code]; ok {
+		switch ev.Value {
+		case KeyReleased:
+			delete(l.remapped, code)
+			return releaseEvents(to, ev.Time)
+		case KeyRepeated:
+			return repeatEvents(to, ev.Time)
+		default:
+			return nil
+		}
+	}
+
+	if ev.Value != KeyPressed {
+		return []golibevdev.Event{ev}
+	}
+
+	if lk, ok := l.layers.LayerKey(code); ok {
+		l.activators[code] = lk
+		l.layers.ActivateLayer(lk.Layer, lk.Mode)
+		return nil
+	}
+
+	if to, ok := l.layers.MapKey(code); ok {
+		l.remapped[code] = to
+		return pressEvents(to, ev.Time)
+	}
+	return []golibevdev.Event{ev}
+}
+
+func (l *layer) Deadline() time.Time {
+	return time.Time{}
+}
+
+func (l *layer) Expire(time.Time) []golibevdev.Event {
+	return nil
+}
@@ -1,26 +1,26 @@
 package handler
 
 import (
+	"slices"
+
 	"github.com/jialeicui/golibevdev"
 
 	"github.com/jialeicui/keyswift/pkg/keys"
 )
 
-var (
-	passThroughKeys = map[golibevdev.KeyEventCode]struct{}{
-		golibevdev.KeyLeftCtrl:  {},
-		golibevdev.KeyRightCtrl: {},
-		golibevdev.KeyLeftAlt:   {},
-		golibevdev.KeyRightAlt:  {},
-	}
-)
-
 type ModifierState struct {
 	pressed bool
+	// tapped is true while the modifier is pressed alone
+	tapped bool
+	// armed applies the sticky modifier to the next non-modifier key
+	armed bool
+	// locked applies the sticky modifier to all keys until it's tapped again
+	locked bool
 }
 
 func (m *ModifierState) Press() {
 	m.pressed = true
+	m.tapped = true
 }
 
 func (m *ModifierState) Release() {
@@ -35,6 +35,27 @@ func (m *ModifierState) IsReleased() bool {
 	return !m.pressed
 }
 
+// Tap moves the sticky modifier to the next state: released -> armed -> locked -> released
+func (m *ModifierState) Tap() {
+	switch {
+	case m.locked:
+		m.locked = false
+	case m.armed:
+		m.armed = false
+		m.locked = true
+	default:
+		m.armed = true
+	}
+}
+
+func (m *ModifierState) IsArmed() bool {
+	return m.armed
+}
+
+func (m *ModifierState) IsLocked() bool {
+	return m.locked
+}
+
 type Modifier struct {
 	modifiers map[golibevdev.KeyEventCode]*ModifierState
 }
@@ -77,6 +98,48 @@ func (m *Modifier) IsReleased(code golibevdev.KeyEventCode) bool {
 	return m.modifiers[code].IsReleased()
 }
 
+// IsTapped returns true if the modifier is pressed without any other key
+func (m *Modifier) IsTapped(code golibevdev.KeyEventCode) bool {
+	if !m.IsModifier(code) {
+		return false
+	}
+	state := m.modifiers[code]
+	return state.IsPressed() && state.tapped
+}
+
+// Interrupt marks the pressed modifiers as used with another key
+func (m *Modifier) Interrupt() {
+	for _, state := range m.modifiers {
+		state.tapped = false
+	}
+}
+
+func (m *Modifier) Tap(code golibevdev.KeyEventCode) {
+	if !m.IsModifier(code) {
+		return
+	}
+	m.modifiers[code].Tap()
+}
+
+// Sticky returns the armed and locked modifiers
+func (m *Modifier) Sticky() []golibevdev.KeyEventCode {
+	var codes []golibevdev.KeyEventCode
+	for code, state := range m.modifiers {
+		if state.IsArmed() || state.IsLocked() {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Disarm releases the armed modifiers after they are applied, the locked ones are kept
+func (m *Modifier) Disarm() {
+	for _, state := range m.modifiers {
+		state.armed = false
+	}
+}
+
 func (m *Modifier) IsModifier(code golibevdev.KeyEventCode) bool {
 	_, ok := m.modifiers[code]
 	return ok
@@ -90,8 +153,3 @@ func (m *Modifier) IsAnyModifierPressed() bool {
 	}
 	return false
 }
-
-func (m *Modifier) ShouldPassThrough(code golibevdev.KeyEventCode) bool {
-	_, ok := passThroughKeys[code]
-	return ok
-}
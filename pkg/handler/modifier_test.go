@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModifierSticky(t *testing.T) {
+	must := require.New(t)
+	m := NewModifier()
+	shift := key(t, "shift")
+
+	m.Press(shift)
+	must.True(m.IsTapped(shift))
+	m.Interrupt()
+	must.False(m.IsTapped(shift))
+	m.Release(shift)
+
+	m.Tap(shift)
+	must.Equal([]golibevdev.KeyEventCode{shift}, m.Sticky())
+	m.Disarm()
+	must.Empty(m.Sticky())
+
+	// double tap locks it until the next tap
+	m.Tap(shift)
+	m.Tap(shift)
+	m.Disarm()
+	must.Equal([]golibevdev.KeyEventCode{shift}, m.Sticky())
+	m.Tap(shift)
+	must.Empty(m.Sticky())
+}
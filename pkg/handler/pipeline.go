@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// stage transforms key events before they reach the chord matcher.
+// A stage may hold events back and release them when its deadline expires.
+type stage interface {
+	// Handle consumes a key event and returns the events passed to the next stage
+	Handle(ev golibevdev.Event) []golibevdev.Event
+	// Deadline returns when Expire should be called, zero means nothing is pending
+	Deadline() time.Time
+	// Expire resolves the pending events and returns the events passed to the next stage
+	Expire(now time.Time) []golibevdev.Event
+}
+
+// pipeline feeds key events through the stages into the sink
+type pipeline struct {
+	stages []stage
+	sink   func(ev golibevdev.Event)
+}
+
+func newPipeline(sink func(ev golibevdev.Event), stages ...stage) *pipeline {
+	return &pipeline{
+		stages: stages,
+		sink:   sink,
+	}
+}
+
+// Feed passes a key event from the device into the pipeline
+func (p *pipeline) Feed(ev golibevdev.Event) {
+	p.feed(0, ev)
+}
+
+func (p *pipeline) feed(i int, ev golibevdev.Event) {
+	if i == len(p.stages) {
+		p.sink(ev)
+		return
+	}
+	for _, out := range p.stages[i].Handle(ev) {
+		p.feed(i+1, out)
+	}
+}
+
+// Deadline returns the earliest deadline of the stages
+func (p *pipeline) Deadline() time.Time {
+	var earliest time.Time
+	for _, s := range p.stages {
+		d := s.Deadline()
+		if d.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || d.Before(earliest) {
+			earliest = d
+		}
+	}
+	return earliest
+}
+
+// Expire wakes up the stages whose deadline passed
+func (p *pipeline) Expire(now time.Time) {
+	for i, s := range p.stages {
+		d := s.Deadline()
+		if d.IsZero() || now.Before(d) {
+			continue
+		}
+		for _, out := range s.Expire(now) {
+			p.feed(i+1, out)
+		}
+	}
+}
+
+func keyEvent(code keys.Key, value int32, t time.Time) golibevdev.Event {
+	return golibevdev.Event{
+		Type:  golibevdev.EvKey,
+		Code:  code,
+		Value: value,
+		Time:  t,
+	}
+}
+
+// pressEvents returns the press events of the keys, modifiers first
+func pressEvents(codes []keys.Key, t time.Time) []golibevdev.Event {
+	events := make([]golibevdev.Event, 0, len(codes))
+	for _, code := range codes {
+		if keys.IsModifier(code) {
+			events = append(events, keyEvent(code, KeyPressed, t))
+		}
+	}
+	for _, code := range codes {
+		if !keys.IsModifier(code) {
+			events = append(events, keyEvent(code, KeyPressed, t))
+		}
+	}
+	return events
+}
+
+// repeatEvents returns the repeat events of the keys except the modifiers
+func repeatEvents(codes []keys.Key, t time.Time) []golibevdev.Event {
+	var events []golibevdev.Event
+	for _, code := range codes {
+		if !keys.IsModifier(code) {
+			events = append(events, keyEvent(code, KeyRepeated, t))
+		}
+	}
+	return events
+}
+
+// releaseEvents returns the release events of the keys in the reverse order of pressEvents
+func releaseEvents(codes []keys.Key, t time.Time) []golibevdev.Event {
+	pressed := pressEvents(codes, t)
+	events := make([]golibevdev.Event, 0, len(pressed))
+	for i := len(pressed) - 1; i >= 0; i-- {
+		events = append(events, keyEvent(pressed[i].Code.(keys.Key), KeyReleased, t))
+	}
+	return events
+}
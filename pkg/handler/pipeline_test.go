@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jialeicui/keyswift/pkg/bus"
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+func key(t *testing.T, name string) keys.Key {
+	codes, err := keys.GetKeyCodes([]string{name})
+	require.NoError(t, err)
+	return codes[0]
+}
+
+func press(t *testing.T, name string) golibevdev.Event {
+	return keyEvent(key(t, name), KeyPressed, time.Time{})
+}
+
+func release(t *testing.T, name string) golibevdev.Event {
+	return keyEvent(key(t, name), KeyReleased, time.Time{})
+}
+
+// sinkRecorder collects the events coming out of a pipeline
+type sinkRecorder struct {
+	events []golibevdev.Event
+}
+
+func (r *sinkRecorder) sink(ev golibevdev.Event) {
+	r.events = append(r.events, ev)
+}
+
+func (r *sinkRecorder) take() []golibevdev.Event {
+	events := r.events
+	r.events = nil
+	return events
+}
+
+type tapHolds map[keys.Key]engine.TapHold
+
+func (t tapHolds) TapHold(key keys.Key) (engine.TapHold, bool) {
+	th, ok := t[key]
+	return th, ok
+}
+
+func newCapsTapHold(t *testing.T, modify func(th *engine.TapHold)) tapHolds {
+	th := engine.TapHold{
+		Key:     key(t, "capslock"),
+		Tap:     []keys.Key{key(t, "esc")},
+		Hold:    []keys.Key{key(t, "ctrl")},
+		Timeout: time.Hour,
+	}
+	if modify != nil {
+		modify(&th)
+	}
+	return tapHolds{th.Key: th}
+}
+
+func TestTapHoldTap(t *testing.T) {
+	must := require.New(t)
+	r := &sinkRecorder{}
+	p := newPipeline(r.sink, newTapHold(newCapsTapHold(t, nil)))
+
+	p.Feed(press(t, "capslock"))
+	must.Empty(r.take())
+	must.False(p.Deadline().IsZero())
+
+	p.Feed(release(t, "capslock"))
+	must.Equal([]golibevdev.Event{press(t, "esc"), release(t, "esc")}, r.take())
+	must.True(p.Deadline().IsZero())
+}
+
+func TestTapHoldTimeout(t *testing.T) {
+	must := require.New(t)
+	r := &sinkRecorder{}
+	p := newPipeline(r.sink, newTapHold(newCapsTapHold(t, nil)))
+
+	p.Feed(press(t, "capslock"))
+	p.Feed(press(t, "c"))
+	must.Empty(r.take())
+
+	p.Expire(p.Deadline())
+	must.Equal([]golibevdev.Event{press(t, "ctrl"), press(t, "c")}, r.take())
+
+	p.Feed(release(t, "c"))
+	p.Feed(release(t, "capslock"))
+	must.Equal([]golibevdev.Event{release(t, "c"), release(t, "ctrl")}, r.take())
+}
+
+func TestTapHoldStrategies(t *testing.T) {
+	must := require.New(t)
+
+	// the other key is released after the tap-hold key, that's a tap by default
+	r := &sinkRecorder{}
+	p := newPipeline(r.sink, newTapHold(newCapsTapHold(t, nil)))
+	p.Feed(press(t, "capslock"))
+	p.Feed(press(t, "c"))
+	p.Feed(release(t, "c"))
+	must.Empty(r.take())
+	p.Feed(release(t, "capslock"))
+	must.Equal([]golibevdev.Event{press(t, "esc"), release(t, "esc"), press(t, "c"), release(t, "c")}, r.take())
+
+	r = &sinkRecorder{}
+	p = newPipeline(r.sink, newTapHold(newCapsTapHold(t, func(th *engine.TapHold) {
+		th.PermissiveHold = true
+	})))
+	p.Feed(press(t, "capslock"))
+	p.Feed(press(t, "c"))
+	must.Empty(r.take())
+	p.Feed(release(t, "c"))
+	must.Equal([]golibevdev.Event{press(t, "ctrl"), press(t, "c"), release(t, "c")}, r.take())
+
+	r = &sinkRecorder{}
+	p = newPipeline(r.sink, newTapHold(newCapsTapHold(t, func(th *engine.TapHold) {
+		th.HoldOnOtherKeyPress = true
+	})))
+	p.Feed(press(t, "capslock"))
+	p.Feed(press(t, "c"))
+	must.Equal([]golibevdev.Event{press(t, "ctrl"), press(t, "c")}, r.take())
+}
+
+// comboRecorder records the combos fired
+type comboRecorder struct {
+	combos []engine.Combo
+	fired  []int
+}
+
+func (r *comboRecorder) Combos() []engine.Combo {
+	return r.combos
+}
+
+func (r *comboRecorder) RunCombo(combo engine.Combo, _ *engine.Device, _ time.Time) (bus.Result, error) {
+	r.fired = append(r.fired, combo.ID)
+	return bus.Result{Handled: true}, nil
+}
+
+func newComboRecorder(t *testing.T) *comboRecorder {
+	return &comboRecorder{combos: []engine.Combo{
+		{ID: 0, Keys: []keys.Key{key(t, "j"), key(t, "k")}, Timeout: time.Hour},
+		{ID: 1, Keys: []keys.Key{key(t, "s"), key(t, "d")}, Timeout: time.Hour},
+		{ID: 2, Keys: []keys.Key{key(t, "s"), key(t, "d"), key(t, "f")}, Timeout: time.Hour},
+	}}
+}
+
+func TestCombo(t *testing.T) {
+	must := require.New(t)
+	r := &sinkRecorder{}
+	combos := newComboRecorder(t)
+	p := newPipeline(r.sink, newCombo(combos, nil))
+
+	p.Feed(press(t, "k"))
+	must.Empty(r.take())
+	p.Feed(press(t, "j"))
+	must.Equal([]int{0}, combos.fired)
+	p.Feed(release(t, "j"))
+	p.Feed(release(t, "k"))
+	must.Empty(r.take())
+
+	// a key outside of the combo is typed after the buffered key
+	p.Feed(press(t, "j"))
+	p.Feed(press(t, "a"))
+	must.Equal([]golibevdev.Event{press(t, "j"), press(t, "a")}, r.take())
+
+	// a single key is typed when released
+	p.Feed(release(t, "a"))
+	p.Feed(release(t, "j"))
+	p.Feed(press(t, "k"))
+	p.Feed(release(t, "k"))
+	must.Equal([]golibevdev.Event{release(t, "a"), release(t, "j"), press(t, "k"), release(t, "k")}, r.take())
+	must.Equal([]int{0}, combos.fired)
+}
+
+func TestComboDevices(t *testing.T) {
+	must := require.New(t)
+	r := &sinkRecorder{}
+	combos := newComboRecorder(t)
+	combos.combos[0].Devices = engine.DeviceFilter{regexp.MustCompile("HHKB")}
+	p := newPipeline(r.sink, newCombo(combos, &engine.Device{Name: "AT Translated Set 2 keyboard"}))
+
+	// the combo of another keyboard is typed as is
+	p.Feed(press(t, "j"))
+	p.Feed(press(t, "k"))
+	must.Equal([]golibevdev.Event{press(t, "j"), press(t, "k")}, r.take())
+	must.Empty(combos.fired)
+}
+
+func TestComboOverlap(t *testing.T) {
+	must := require.New(t)
+	r := &sinkRecorder{}
+	combos := newComboRecorder(t)
+	p := newPipeline(r.sink, newCombo(combos, nil))
+
+	// s+d waits for f until the timeout
+	p.Feed(press(t, "s"))
+	p.Feed(press(t, "d"))
+	must.Empty(combos.fired)
+	p.Expire(p.Deadline())
+	must.Equal([]int{1}, combos.fired)
+
+	p.Feed(release(t, "s"))
+	p.Feed(release(t, "d"))
+	p.Feed(press(t, "d"))
+	p.Feed(press(t, "f"))
+	p.Feed(press(t, "s"))
+	must.Equal([]int{1, 2}, combos.fired)
+	must.Empty(r.take())
+
+	// not pressed in time, typed as is
+	p.Feed(release(t, "d"))
+	p.Feed(release(t, "f"))
+	p.Feed(release(t, "s"))
+	p.Feed(press(t, "s"))
+	p.Expire(p.Deadline())
+	must.Equal([]golibevdev.Event{press(t, "s")}, r.take())
+}
+
+// fakeLayers activates a single layer without stacking
+type fakeLayers struct {
+	layer  engine.Layer
+	keys   map[keys.Key]engine.LayerKey
+	active bool
+}
+
+func (f *fakeLayers) LayerKey(key keys.Key) (engine.LayerKey, bool) {
+	lk, ok := f.keys[key]
+	return lk, ok
+}
+
+func (f *fakeLayers) ActivateLayer(_ string, mode engine.LayerMode) {
+	f.active = mode != engine.LayerToggle || !f.active
+}
+
+func (f *fakeLayers) DeactivateLayer(string) {
+	f.active = false
+}
+
+func (f *fakeLayers) MapKey(key keys.Key) ([]keys.Key, bool) {
+	if !f.active {
+		return nil, false
+	}
+	to, ok := f.layer.Keys[key]
+	return to, ok
+}
+
+func TestLayer(t *testing.T) {
+	must := require.New(t)
+	layers := &fakeLayers{
+		layer: engine.Layer{Name: "nav", Keys: map[keys.Key][]keys.Key{
+			key(t, "h"): {key(t, "left")},
+			key(t, "w"): {key(t, "ctrl"), key(t, "right")},
+		}},
+		keys: map[keys.Key]engine.LayerKey{
+			key(t, "space"): {Key: key(t, "space"), Layer: "nav", Mode: engine.LayerMomentary},
+			key(t, "f12"):   {Key: key(t, "f12"), Layer: "nav", Mode: engine.LayerToggle},
+		},
+	}
+	r := &sinkRecorder{}
+	p := newPipeline(r.sink, newLayer(layers))
+
+	p.Feed(press(t, "space"))
+	p.Feed(press(t, "h"))
+	p.Feed(keyEvent(key(t, "h"), KeyRepeated, time.Time{}))
+	// the layer is released before the remapped key
+	p.Feed(release(t, "space"))
+	p.Feed(release(t, "h"))
+	must.Equal([]golibevdev.Event{
+		press(t, "left"),
+		keyEvent(key(t, "left"), KeyRepeated, time.Time{}),
+		release(t, "left"),
+	}, r.take())
+
+	p.Feed(press(t, "h"))
+	must.Equal([]golibevdev.Event{press(t, "h")}, r.take())
+	p.Feed(release(t, "h"))
+	r.take()
+
+	p.Feed(press(t, "f12"))
+	p.Feed(release(t, "f12"))
+	p.Feed(press(t, "w"))
+	p.Feed(release(t, "w"))
+	must.Equal([]golibevdev.Event{press(t, "ctrl"), press(t, "right"), release(t, "right"), release(t, "ctrl")}, r.take())
+	must.True(layers.active)
+}
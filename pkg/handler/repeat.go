@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// autoRepeat repeats the chord sent by the callbacks while the keys triggering it are held.
+// The chord is pressed again and held at the first repeat, then its keys are repeated with the events of value 2
+// at the configured rate, like a keyboard does, until it's released by stopRepeat.
+type autoRepeat struct {
+	chord    []keys.Key
+	interval time.Duration
+	next     time.Time
+	// held is true once the chord is pressed on the virtual keyboard
+	held bool
+}
+
+// startRepeat starts repeating the chord sent for the pressed keys after the repeat delay
+func (s *deviceState) startRepeat(chord []keys.Key) {
+	s.stopRepeat()
+	if len(chord) == 0 {
+		return
+	}
+	repeat := s.modeManager.KeyRepeat()
+	if !repeat.Enabled {
+		return
+	}
+	s.repeat = &autoRepeat{
+		chord:    chord,
+		interval: repeat.Interval,
+		next:     time.Now().Add(repeat.Delay),
+	}
+}
+
+// stopRepeat stops the repeat and releases the chord held by it
+func (s *deviceState) stopRepeat() {
+	if s.repeat != nil && s.repeat.held {
+		s.modeManager.ReleaseKeys(s.repeat.chord)
+	}
+	s.repeat = nil
+}
+
+// expireRepeat presses the chord or repeats it when the next repeat is due
+func (s *deviceState) expireRepeat(now time.Time) {
+	if s.repeat == nil || now.Before(s.repeat.next) {
+		return
+	}
+
+	slog.Debug("repeat keys", "keys", s.repeat.chord, "held", s.repeat.held)
+	if s.repeat.held {
+		s.modeManager.RepeatKeys(s.repeat.chord)
+	} else {
+		s.modeManager.PressKeys(s.repeat.chord)
+		s.repeat.held = true
+	}
+	s.repeat.next = s.repeat.next.Add(s.repeat.interval)
+	if s.repeat.next.Before(now) {
+		// don't catch up after a stall
+		s.repeat.next = now.Add(s.repeat.interval)
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/engine"
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+var _ stage = (*tapHold)(nil)
+
+type tapHoldGetter interface {
+	TapHold(key keys.Key) (engine.TapHold, bool)
+}
+
+// tapHold resolves the keys bound by KeySwift.onTapHold.
+// The press of such a key is buffered together with the following events
+// until the release, another key or the timeout decides between tap and hold.
+type tapHold struct {
+	bindings tapHoldGetter
+	pending  *pendingTapHold
+	// held maps the physical keys resolved as hold to the keys they are holding
+	held map[golibevdev.KeyEventCode][]keys.Key
+}
+
+type pendingTapHold struct {
+	binding  engine.TapHold
+	time     time.Time
+	deadline time.Time
+	buffered []golibevdev.Event
+	// pressed records the keys pressed after the tap-hold key
+	pressed map[golibevdev.KeyEventCode]struct{}
+}
+
+func newTapHold(bindings tapHoldGetter) *tapHold {
+	return &tapHold{
+		bindings: bindings,
+		held:     make(map[golibevdev.KeyEventCode][]keys.Key),
+	}
+}
+
+func (t *tapHold) Handle(ev golibevdev.Event) []golibevdev.Event {
+	code := ev.Code.(golibevdev.KeyEventCode)
+	if t.pending != nil {
+		return t.handlePending(ev, code)
+	}
+
+	if hold, ok := t.held[code]; ok {
+		if ev.Value != KeyReleased {
+			return nil
+		}
+		delete(t.held, code)
+		return releaseEvents(hold, ev.Time)
+	}
+
+	if ev.Value == KeyPressed {
+		if binding, ok := t.bindings.TapHold(code); ok {
+			t.pending = &pendingTapHold{
+				binding:  binding,
+				time:     ev.Time,
+				deadline: time.Now().Add(binding.Timeout),
+				pressed:  make(map[golibevdev.KeyEventCode]struct{}),
+			}
+			return nil
+		}
+	}
+
+	return []golibevdev.Event{ev}
+}
+
+func (t *tapHold) handlePending(ev golibevdev.Event, code golibevdev.KeyEventCode) []golibevdev.Event {
+	p := t.pending
+	if code == p.binding.Key {
+		if ev.Value == KeyReleased {
+			return t.resolve(false)
+		}
+		// drop the repeat events
+		return nil
+	}
+
+	p.buffered = append(p.buffered, ev)
+	switch ev.Value {
+	case KeyPressed:
+		p.pressed[code] = struct{}{}
+		if p.binding.HoldOnOtherKeyPress {
+			return t.resolve(true)
+		}
+	case KeyReleased:
+		if _, ok := p.pressed[code]; ok && p.binding.PermissiveHold {
+			return t.resolve(true)
+		}
+	}
+	return nil
+}
+
+// resolve emits the tap or the hold keys and replays the buffered events
+func (t *tapHold) resolve(hold bool) []golibevdev.Event {
+	p := t.pending
+	t.pending = nil
+
+	var out []golibevdev.Event
+	if hold {
+		t.held[p.binding.Key] = p.binding.Hold
+		out = pressEvents(p.binding.Hold, p.time)
+	} else {
+		out = append(pressEvents(p.binding.Tap, p.time), releaseEvents(p.binding.Tap, p.time)...)
+	}
+
+	for _, ev := range p.buffered {
+		out = append(out, t.Handle(ev)...)
+	}
+	return out
+}
+
+func (t *tapHold) Deadline() time.Time {
+	if t.pending == nil {
+		return time.Time{}
+	}
+	return t.pending.deadline
+}
+
+func (t *tapHold) Expire(time.Time) []golibevdev.Event {
+	if t.pending == nil {
+		return nil
+	}
+	return t.resolve(true)
+}
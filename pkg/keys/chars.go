@@ -0,0 +1,89 @@
+package keys
+
+import (
+	"github.com/jialeicui/golibevdev"
+)
+
+// charKey is the key typing a character on the US layout
+type charKey struct {
+	key   Key
+	shift bool
+}
+
+var (
+	charKeys = map[rune]charKey{
+		' ':  {golibevdev.KeySpace, false},
+		'\n': {golibevdev.KeyEnter, false},
+		'\t': {golibevdev.KeyTab, false},
+	}
+	keyChars = map[charKey]rune{}
+)
+
+func init() {
+	letters := []Key{
+		golibevdev.KeyA, golibevdev.KeyB, golibevdev.KeyC, golibevdev.KeyD, golibevdev.KeyE,
+		golibevdev.KeyF, golibevdev.KeyG, golibevdev.KeyH, golibevdev.KeyI, golibevdev.KeyJ,
+		golibevdev.KeyK, golibevdev.KeyL, golibevdev.KeyM, golibevdev.KeyN, golibevdev.KeyO,
+		golibevdev.KeyP, golibevdev.KeyQ, golibevdev.KeyR, golibevdev.KeyS, golibevdev.KeyT,
+		golibevdev.KeyU, golibevdev.KeyV, golibevdev.KeyW, golibevdev.KeyX, golibevdev.KeyY,
+		golibevdev.KeyZ,
+	}
+	for i, key := range letters {
+		charKeys[rune('a'+i)] = charKey{key, false}
+		charKeys[rune('A'+i)] = charKey{key, true}
+	}
+
+	digits := []Key{
+		golibevdev.Key0, golibevdev.Key1, golibevdev.Key2, golibevdev.Key3, golibevdev.Key4,
+		golibevdev.Key5, golibevdev.Key6, golibevdev.Key7, golibevdev.Key8, golibevdev.Key9,
+	}
+	for i, key := range digits {
+		charKeys[rune('0'+i)] = charKey{key, false}
+	}
+	for i, c := range ")!@#$%^&*(" {
+		charKeys[c] = charKey{digits[i], true}
+	}
+
+	symbols := []struct {
+		key          Key
+		normal, with rune
+	}{
+		{golibevdev.KeyMinus, '-', '_'},
+		{golibevdev.KeyEqual, '=', '+'},
+		{golibevdev.KeyLeftBrace, '[', '{'},
+		{golibevdev.KeyRightBrace, ']', '}'},
+		{golibevdev.KeySemicolon, ';', ':'},
+		{golibevdev.KeyApostrophe, '\'', '"'},
+		{golibevdev.KeyGrave, '`', '~'},
+		{golibevdev.KeyBackslash, '\\', '|'},
+		{golibevdev.KeyComma, ',', '<'},
+		{golibevdev.KeyDot, '.', '>'},
+		{golibevdev.KeySlash, '/', '?'},
+	}
+	for _, s := range symbols {
+		charKeys[s.normal] = charKey{s.key, false}
+		charKeys[s.with] = charKey{s.key, true}
+	}
+
+	for c, k := range charKeys {
+		keyChars[k] = c
+	}
+}
+
+// CharKeys returns the keys typing the character on the US layout
+func CharKeys(c rune) ([]Key, bool) {
+	k, ok := charKeys[c]
+	if !ok {
+		return nil, false
+	}
+	if k.shift {
+		return []Key{golibevdev.KeyLeftShift, k.key}, true
+	}
+	return []Key{k.key}, true
+}
+
+// Char returns the character typed by the key on the US layout
+func Char(key Key, shift bool) (rune, bool) {
+	c, ok := keyChars[charKey{key, shift}]
+	return c, ok
+}
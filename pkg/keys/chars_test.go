@@ -0,0 +1,30 @@
+package keys
+
+import (
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChars(t *testing.T) {
+	must := require.New(t)
+
+	for _, c := range "Best regards,\nJialei! ~(a_b)" {
+		codes, ok := CharKeys(c)
+		must.True(ok, string(c))
+
+		shift := len(codes) == 2
+		if shift {
+			must.Equal(golibevdev.KeyLeftShift, codes[0])
+		}
+		typed, ok := Char(codes[len(codes)-1], shift)
+		must.True(ok)
+		must.Equal(c, typed)
+	}
+
+	_, ok := CharKeys('é')
+	must.False(ok)
+	_, ok = Char(golibevdev.KeyLeft, false)
+	must.False(ok)
+}
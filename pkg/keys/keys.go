@@ -17,6 +17,25 @@ var Modifiers = map[Key]struct{}{
 	golibevdev.KeyRightShift: {},
 	golibevdev.KeyRightCtrl:  {},
 	golibevdev.KeyRightAlt:   {},
+	golibevdev.KeyRightMeta:  {},
+}
+
+// genericModifiers are the modifier names matching either side, they're sent as the left one
+var genericModifiers = map[string]struct{}{
+	"ctrl":  {},
+	"alt":   {},
+	"cmd":   {},
+	"meta":  {},
+	"super": {},
+	"shift": {},
+}
+
+// leftModifiers maps the right modifiers to the left ones
+var leftModifiers = map[Key]Key{
+	golibevdev.KeyRightShift: golibevdev.KeyLeftShift,
+	golibevdev.KeyRightCtrl:  golibevdev.KeyLeftCtrl,
+	golibevdev.KeyRightAlt:   golibevdev.KeyLeftAlt,
+	golibevdev.KeyRightMeta:  golibevdev.KeyLeftMeta,
 }
 
 var keyMap = map[string]Key{
@@ -54,13 +73,17 @@ var keyMap = map[string]Key{
 
 func init() {
 	for code := golibevdev.KeyReserved + 1; code < golibevdev.KeyMax; code++ {
-		name := strings.TrimPrefix(code.String(), "Key")
-		name = strings.ToLower(name)
-		name = strings.ReplaceAll(name, "_", "-")
-		keyMap[name] = code
+		keyMap[Name(code)] = code
 	}
 }
 
+// Name returns the name of the key used in scripts
+func Name(key Key) string {
+	name := strings.TrimPrefix(key.String(), "Key")
+	name = strings.ToLower(name)
+	return strings.ReplaceAll(name, "_", "-")
+}
+
 func GetKeyCodes(keys []string) ([]Key, error) {
 	keyCodes := make([]Key, 0, len(keys))
 	for _, key := range keys {
@@ -77,3 +100,28 @@ func IsModifier(key golibevdev.KeyEventCode) bool {
 	_, ok := Modifiers[key]
 	return ok
 }
+
+// IsGeneric returns true if the name is a modifier matching either side, like "ctrl"
+func IsGeneric(name string) bool {
+	_, ok := genericModifiers[strings.ToLower(name)]
+	return ok
+}
+
+// Sides returns the left and the right modifier of a modifier, the other keys are returned as is
+func Sides(key Key) []Key {
+	left := Normalize(key)
+	for right, l := range leftModifiers {
+		if l == left {
+			return []Key{left, right}
+		}
+	}
+	return []Key{key}
+}
+
+// Normalize returns the left modifier of a right one, the other keys are returned as is
+func Normalize(key Key) Key {
+	if left, ok := leftModifiers[key]; ok {
+		return left
+	}
+	return key
+}
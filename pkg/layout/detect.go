@@ -0,0 +1,137 @@
+package layout
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultLayout is used when no layout is configured
+const DefaultLayout = "us"
+
+var (
+	xorgOptionRe = regexp.MustCompile(`Option\s+"(XkbLayout|XkbVariant)"\s+"([^"]*)"`)
+	// gnomeSourceRe matches the xkb sources of the GNOME input sources like ('xkb', 'de+nodeadkeys')
+	gnomeSourceRe = regexp.MustCompile(`\(\s*'xkb'\s*,\s*'([^']+)'\s*\)`)
+)
+
+// gsettingsTimeout bounds the query of the GNOME settings
+const gsettingsTimeout = time.Second
+
+// Detect returns the layout the session types with, the first one if there are several.
+// It looks at the input sources of GNOME, the layouts of KDE, then at XKB_DEFAULT_LAYOUT,
+// /etc/default/keyboard and the X11 keyboard config written by localectl.
+func Detect() (name, variant string) {
+	// the most recently used source is the current one
+	for _, key := range []string{"mru-sources", "sources"} {
+		if name, variant, ok := gnomeLayout(key); ok {
+			return name, variant
+		}
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		if data, err := os.ReadFile(filepath.Join(dir, "kxkbrc")); err == nil {
+			if name, variant, ok := parseKxkbrc(string(data)); ok {
+				return name, variant
+			}
+		}
+	}
+
+	if name = os.Getenv("XKB_DEFAULT_LAYOUT"); name != "" {
+		return first(name), first(os.Getenv("XKB_DEFAULT_VARIANT"))
+	}
+
+	if f, err := os.Open("/etc/default/keyboard"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			k, v, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !ok {
+				continue
+			}
+			v = strings.Trim(v, `"'`)
+			switch k {
+			case "XKBLAYOUT":
+				name = first(v)
+			case "XKBVARIANT":
+				variant = first(v)
+			}
+		}
+		if name != "" {
+			return name, variant
+		}
+	}
+
+	if data, err := os.ReadFile("/etc/X11/xorg.conf.d/00-keyboard.conf"); err == nil {
+		for _, m := range xorgOptionRe.FindAllStringSubmatch(string(data), -1) {
+			if m[1] == "XkbLayout" {
+				name = first(m[2])
+			} else {
+				variant = first(m[2])
+			}
+		}
+		if name != "" {
+			return name, variant
+		}
+	}
+
+	return DefaultLayout, ""
+}
+
+// gnomeLayout returns the first xkb layout of the GNOME input sources under the key
+func gnomeLayout(key string) (name, variant string, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), gsettingsTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "gsettings", "get", "org.gnome.desktop.input-sources", key).Output()
+	if err != nil {
+		return "", "", false
+	}
+	return parseGnomeSources(string(out))
+}
+
+// parseGnomeSources returns the first xkb layout of input sources like [('xkb', 'us'), ('xkb', 'de+nodeadkeys')]
+func parseGnomeSources(sources string) (name, variant string, ok bool) {
+	m := gnomeSourceRe.FindStringSubmatch(sources)
+	if m == nil {
+		return "", "", false
+	}
+	name, variant, _ = strings.Cut(m[1], "+")
+	return name, variant, true
+}
+
+// parseKxkbrc returns the first layout of the KDE keyboard config if KDE sets the layouts
+func parseKxkbrc(data string) (name, variant string, ok bool) {
+	section := ""
+	use := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		k, v, found := strings.Cut(line, "=")
+		if section != "[Layout]" || !found {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "Use":
+			use = strings.TrimSpace(v) == "true"
+		case "LayoutList":
+			name = first(v)
+		case "VariantList":
+			variant = first(v)
+		}
+	}
+	return name, variant, use && name != ""
+}
+
+// first returns the first item of a comma separated list
+func first(list string) string {
+	item, _, _ := strings.Cut(list, ",")
+	return strings.TrimSpace(item)
+}
@@ -0,0 +1,65 @@
+// Command gen generates the keysym table of the layout package from keysymdef.h.
+// The header is installed by xorgproto (x11proto-dev on Debian and Ubuntu, xorgproto on Fedora and Arch),
+// it's released under the MIT license of The Open Group, the table only keeps the keysym names and code points.
+// The table is generated from xorgproto 2022.1.
+// Only the keysyms mapped to a character are kept, the ones noted as an approximate mapping
+// like /*(U+2500 ...)*/ are left out.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/tabwriter"
+)
+
+var defineRe = regexp.MustCompile(`^#define XK_(\w+)\s+0x[0-9a-fA-F]+\s*/\* U\+([0-9A-F]{4,6}) `)
+
+func main() {
+	in := flag.String("in", "/usr/include/X11/keysymdef.h", "the keysymdef.h to read")
+	out := flag.String("out", "keysyms.go", "the go file to write")
+	flag.Parse()
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated from %s by gen. DO NOT EDIT.\n\n", filepath.Base(*in))
+	buf.WriteString("package layout\n\n")
+	buf.WriteString("// keysyms maps the keysym names to the characters they type\n")
+	buf.WriteString("var keysyms = map[string]rune{\n")
+
+	w := tabwriter.NewWriter(buf, 0, 8, 1, ' ', 0)
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := defineRe.FindStringSubmatch(scanner.Text())
+		if m == nil || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		fmt.Fprintf(w, "\t%q:\t0x%s,\n", m[1], m[2])
+	}
+	if err = scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
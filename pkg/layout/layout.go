@@ -0,0 +1,266 @@
+package layout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jialeicui/golibevdev"
+
+	"github.com/jialeicui/keyswift/pkg/keys"
+)
+
+// DefaultDir is where the XKB data is installed
+const DefaultDir = "/usr/share/X11/xkb"
+
+// maxIncludeDepth guards against include cycles in the symbols files
+const maxIncludeDepth = 16
+
+// Stroke is the key and the modifiers typing a character
+type Stroke struct {
+	Key   keys.Key
+	Shift bool
+	AltGr bool
+}
+
+// Keys returns the keys pressed together for the stroke, modifiers first
+func (s Stroke) Keys() []keys.Key {
+	var codes []keys.Key
+	if s.Shift {
+		codes = append(codes, golibevdev.KeyLeftShift)
+	}
+	if s.AltGr {
+		codes = append(codes, golibevdev.KeyRightAlt)
+	}
+	return append(codes, s.Key)
+}
+
+// level returns the shift level of the stroke, 0 is the base level
+func (s Stroke) level() int {
+	level := 0
+	if s.Shift {
+		level++
+	}
+	if s.AltGr {
+		level += 2
+	}
+	return level
+}
+
+// Layout maps characters to the keystrokes typing them
+type Layout struct {
+	Name    string
+	Variant string
+
+	strokes map[rune]Stroke
+	chars   map[Stroke]rune
+}
+
+// Stroke returns the keystroke typing the character
+func (l *Layout) Stroke(c rune) (Stroke, bool) {
+	s, ok := l.strokes[c]
+	return s, ok
+}
+
+// Char returns the character typed by the keystroke
+func (l *Layout) Char(s Stroke) (rune, bool) {
+	c, ok := l.chars[s]
+	return c, ok
+}
+
+// Load parses the layout and its variant from the XKB data in dir, e.g. Load(DefaultDir, "de", "nodeadkeys").
+// An empty variant is the default variant of the layout.
+func Load(dir, name, variant string) (*Layout, error) {
+	codes, err := loadKeycodes(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := map[string][]string{}
+	if err = loadSymbols(dir, name, variant, symbols, 0); err != nil {
+		return nil, err
+	}
+
+	l := &Layout{
+		Name:    name,
+		Variant: variant,
+		strokes: map[rune]Stroke{},
+		chars:   map[Stroke]rune{},
+	}
+	for keyName, levels := range symbols {
+		code, ok := codes[keyName]
+		if !ok {
+			continue
+		}
+		for level, sym := range levels {
+			if level > 3 {
+				break
+			}
+			c, ok := keysymChar(sym)
+			if !ok {
+				continue
+			}
+			s := Stroke{Key: code, Shift: level%2 == 1, AltGr: level >= 2}
+			l.chars[s] = c
+			// the simplest stroke wins, then the lowest key code to keep it stable
+			if prev, ok := l.strokes[c]; ok && (prev.level() < s.level() || (prev.level() == s.level() && prev.Key < s.Key)) {
+				continue
+			}
+			l.strokes[c] = s
+		}
+	}
+
+	// the whitespace keys are usually defined outside of the layouts
+	for c, key := range map[rune]keys.Key{' ': golibevdev.KeySpace, '\n': golibevdev.KeyEnter, '\t': golibevdev.KeyTab} {
+		if _, ok := l.strokes[c]; !ok {
+			l.strokes[c] = Stroke{Key: key}
+			l.chars[Stroke{Key: key}] = c
+		}
+	}
+	return l, nil
+}
+
+var (
+	keycodeRe = regexp.MustCompile(`<(\w+)>\s*=\s*(\d+)\s*;`)
+	aliasRe   = regexp.MustCompile(`alias\s*<(\w+)>\s*=\s*<(\w+)>\s*;`)
+)
+
+// loadKeycodes returns the evdev codes of the XKB key names
+func loadKeycodes(dir string) (map[string]keys.Key, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "keycodes", "evdev"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keycodes: %w", err)
+	}
+	// the aliases are optional, most of them are in the evdev file as well
+	aliases, _ := os.ReadFile(filepath.Join(dir, "keycodes", "aliases"))
+
+	codes := map[string]keys.Key{}
+	for _, m := range keycodeRe.FindAllStringSubmatch(string(data), -1) {
+		code, _ := strconv.Atoi(m[2])
+		// XKB keycodes are evdev codes plus 8
+		codes[m[1]] = keys.Key(code - 8)
+	}
+	for _, m := range aliasRe.FindAllStringSubmatch(string(data)+string(aliases), -1) {
+		if code, ok := codes[m[2]]; ok {
+			if _, defined := codes[m[1]]; !defined {
+				codes[m[1]] = code
+			}
+		}
+	}
+	return codes, nil
+}
+
+var (
+	commentRe = regexp.MustCompile(`//.*`)
+	blockRe   = regexp.MustCompile(`((?:\w+\s+)*)xkb_symbols\s+"([^"]+)"\s*\{`)
+	// statementRe matches the includes and the key definitions in their order
+	statementRe   = regexp.MustCompile(`include\s+"([^"]+)"|\bkey\s*<(\w+)>\s*\{([^}]*)\}`)
+	groupOptionRe = regexp.MustCompile(`(?:type|actions)\[\w+\]\s*=\s*("[^"]*"|\[[^\]]*\])|symbols\[\w+\]\s*=`)
+	levelsRe      = regexp.MustCompile(`\[([^\]]*)\]`)
+	includeFileRe = regexp.MustCompile(`^([\w\-/]+)(?:\(([\w\-]+)\))?(?::\d+)?$`)
+)
+
+// loadSymbols parses the symbols of a variant into the levels of the keys, the later ones override
+func loadSymbols(dir, name, variant string, symbols map[string][]string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("too many nested includes in %s(%s)", name, variant)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "symbols", name))
+	if err != nil {
+		return fmt.Errorf("failed to read symbols: %w", err)
+	}
+
+	body, err := findBlock(commentRe.ReplaceAllString(string(data), ""), variant)
+	if err != nil {
+		return fmt.Errorf("symbols %s: %w", name, err)
+	}
+
+	for _, m := range statementRe.FindAllStringSubmatch(body, -1) {
+		if m[1] != "" {
+			for _, inc := range strings.FieldsFunc(m[1], func(r rune) bool { return r == '+' || r == '|' }) {
+				im := includeFileRe.FindStringSubmatch(inc)
+				if im == nil {
+					return fmt.Errorf("bad include %q in %s", inc, name)
+				}
+				if err = loadSymbols(dir, im[1], im[2], symbols, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		lm := levelsRe.FindStringSubmatch(groupOptionRe.ReplaceAllString(m[3], ""))
+		if lm == nil {
+			continue
+		}
+		levels := strings.Split(lm[1], ",")
+		for i := range levels {
+			levels[i] = strings.TrimSpace(levels[i])
+		}
+		symbols[m[2]] = levels
+	}
+	return nil
+}
+
+// findBlock returns the body of the xkb_symbols block of the variant,
+// an empty variant is the block marked as default or the first one
+func findBlock(data, variant string) (string, error) {
+	matches := blockRe.FindAllStringSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no xkb_symbols found")
+	}
+
+	chosen := -1
+	for i, m := range matches {
+		flags, blockName := data[m[2]:m[3]], data[m[4]:m[5]]
+		if variant == blockName || (variant == "" && strings.Contains(flags, "default")) {
+			chosen = i
+			break
+		}
+	}
+	if chosen < 0 {
+		if variant != "" {
+			return "", fmt.Errorf("variant %s not found", variant)
+		}
+		chosen = 0
+	}
+
+	start := matches[chosen][1]
+	depth := 1
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return data[start:i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unterminated xkb_symbols block")
+}
+
+//go:generate go run ./gen -out keysyms.go
+
+// keysymChar returns the character typed by a keysym like a, adiaeresis, U2032 or 0x1001E9E
+func keysymChar(sym string) (rune, bool) {
+	if c, ok := keysyms[sym]; ok {
+		return c, true
+	}
+	if len(sym) > 1 && sym[0] == 'U' {
+		if v, err := strconv.ParseUint(sym[1:], 16, 32); err == nil {
+			return rune(v), true
+		}
+	}
+	if strings.HasPrefix(sym, "0x") {
+		if v, err := strconv.ParseUint(sym[2:], 16, 32); err == nil && v >= 0x1000000 {
+			return rune(v - 0x1000000), true
+		}
+	}
+	return 0, false
+}
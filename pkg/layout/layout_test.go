@@ -0,0 +1,89 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/jialeicui/golibevdev"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	must := require.New(t)
+
+	l, err := Load("testdata/xkb", "de", "")
+	must.NoError(err)
+
+	for c, expected := range map[rune]Stroke{
+		'z':  {Key: golibevdev.KeyY},
+		'Y':  {Key: golibevdev.KeyZ, Shift: true},
+		'"':  {Key: golibevdev.Key2, Shift: true},
+		'!':  {Key: golibevdev.Key1, Shift: true},
+		'ß':  {Key: golibevdev.KeyMinus},
+		'\\': {Key: golibevdev.KeyMinus, AltGr: true},
+		'€':  {Key: golibevdev.KeyE, AltGr: true},
+		'ä':  {Key: golibevdev.KeyApostrophe},
+		'’':  {Key: golibevdev.KeyBackslash, AltGr: true},
+		'æ':  {Key: golibevdev.KeyA, AltGr: true},
+		' ':  {Key: golibevdev.KeySpace},
+	} {
+		s, ok := l.Stroke(c)
+		must.True(ok, string(c))
+		must.Equal(expected, s, string(c))
+	}
+
+	// 'e' is on the base and the altgr level, the base one wins
+	s, ok := l.Stroke('e')
+	must.True(ok)
+	must.Equal(Stroke{Key: golibevdev.KeyE}, s)
+	must.Equal([]golibevdev.KeyEventCode{golibevdev.KeyLeftShift, golibevdev.KeyRightAlt, golibevdev.KeyE}, Stroke{Key: golibevdev.KeyE, Shift: true, AltGr: true}.Keys())
+
+	c, ok := l.Char(Stroke{Key: golibevdev.KeySemicolon})
+	must.False(ok, "dead keys type nothing")
+	c, ok = l.Char(Stroke{Key: golibevdev.KeyComma, Shift: true})
+	must.True(ok)
+	must.Equal(';', c)
+
+	_, ok = l.Stroke('^')
+	must.False(ok)
+	l, err = Load("testdata/xkb", "de", "nodeadkeys")
+	must.NoError(err)
+	s, ok = l.Stroke('^')
+	must.True(ok)
+	must.Equal(Stroke{Key: golibevdev.KeyApostrophe, AltGr: true}, s)
+
+	_, err = Load("testdata/xkb", "de", "missing")
+	must.ErrorContains(err, "variant missing not found")
+	_, err = Load("testdata/xkb", "fr", "")
+	must.Error(err)
+}
+
+func TestParseSessionLayout(t *testing.T) {
+	must := require.New(t)
+
+	name, variant, ok := parseGnomeSources(`[('ibus', 'anthy'), ('xkb', 'de+nodeadkeys'), ('xkb', 'us')]`)
+	must.True(ok)
+	must.Equal("de", name)
+	must.Equal("nodeadkeys", variant)
+	name, variant, ok = parseGnomeSources(`[('xkb', 'fr')]`)
+	must.True(ok)
+	must.Equal("fr", name)
+	must.Empty(variant)
+	_, _, ok = parseGnomeSources(`@a(ss) []`)
+	must.False(ok)
+
+	name, variant, ok = parseKxkbrc(`
+[$Version]
+update_info=kxkb_variants.upd:split-variants
+
+[Layout]
+DisplayNames=,
+LayoutList=de,us
+Use=true
+VariantList=nodeadkeys,
+`)
+	must.True(ok)
+	must.Equal("de", name)
+	must.Equal("nodeadkeys", variant)
+	_, _, ok = parseKxkbrc("[Layout]\nLayoutList=de\nUse=false\n")
+	must.False(ok)
+}
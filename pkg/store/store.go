@@ -0,0 +1,160 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jialeicui/keyswift/pkg/utils"
+)
+
+// DefaultFlushDelay is how long the changes are collected before they're written to the file
+const DefaultFlushDelay = time.Second
+
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*Store{}
+)
+
+// DefaultPath returns the file of the store kept between runs
+func DefaultPath() (string, error) {
+	dir, err := utils.DefaultStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "store.json"), nil
+}
+
+// Store is a key-value store of JSON values backed by a file,
+// the changes are written to the file atomically in the background
+type Store struct {
+	path  string
+	delay time.Duration
+
+	mu   sync.Mutex
+	data map[string]json.RawMessage
+	// dirty means there are changes not written yet, timer writes them after delay
+	dirty bool
+	timer *time.Timer
+
+	// saveMu keeps the writes of the file in order
+	saveMu sync.Mutex
+}
+
+// Open loads the store from the file, a missing file is an empty store
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		delay: DefaultFlushDelay,
+		data:  map[string]json.RawMessage{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %w", err)
+	}
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// OpenShared returns the store of the file shared in the process, it's opened on the first call.
+// The engines share it while the config is reloaded, so the new one sees the changes not written yet.
+func OpenShared(path string) (*Store, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s, ok := shared[path]; ok {
+		return s, nil
+	}
+	s, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	shared[path] = s
+	return s, nil
+}
+
+// Get returns the JSON value of the key
+func (s *Store) Get(key string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Set sets the JSON value of the key, it's saved in the background
+func (s *Store) Set(key string, value json.RawMessage) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("invalid JSON value of %s", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	s.changed()
+	return nil
+}
+
+// Delete deletes the key, it's saved in the background
+func (s *Store) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return
+	}
+	delete(s.data, key)
+	s.changed()
+}
+
+// changed schedules the write of the changes, the caller holds mu
+func (s *Store) changed() {
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.delay, func() {
+			if err := s.Flush(); err != nil {
+				slog.Error("failed to save store", "error", err)
+			}
+		})
+	}
+}
+
+// Flush writes the changes not written yet, it's called before exiting
+func (s *Store) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = utils.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		// the next change or flush tries again
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return fmt.Errorf("failed to save store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	must := require.New(t)
+	path := filepath.Join(t.TempDir(), "keyswift", "store.json")
+
+	s, err := Open(path)
+	must.NoError(err)
+	_, ok := s.Get("layer")
+	must.False(ok)
+
+	must.NoError(s.Set("layer", json.RawMessage(`"nav"`)))
+	must.NoError(s.Set("count", json.RawMessage(`3`)))
+	s.Delete("count")
+	must.Error(s.Set("bad", json.RawMessage(`{`)))
+
+	// the changes are written in the background
+	_, err = os.Stat(path)
+	must.ErrorIs(err, fs.ErrNotExist)
+	must.NoError(s.Flush())
+
+	// reopened after a restart
+	s, err = Open(path)
+	must.NoError(err)
+	v, ok := s.Get("layer")
+	must.True(ok)
+	must.JSONEq(`"nav"`, string(v))
+	_, ok = s.Get("count")
+	must.False(ok)
+
+	// no temporary file is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	must.NoError(err)
+	must.Len(entries, 1)
+
+	must.NoError(os.WriteFile(path, []byte("{"), 0o600))
+	_, err = Open(path)
+	must.Error(err)
+}
+
+func TestStoreFlushDelay(t *testing.T) {
+	must := require.New(t)
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	s, err := Open(path)
+	must.NoError(err)
+	s.delay = 10 * time.Millisecond
+	for i := range 100 {
+		must.NoError(s.Set("count", json.RawMessage(strconv.Itoa(i))))
+	}
+
+	must.Eventually(func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && string(data) == "{\n  \"count\": 99\n}"
+	}, time.Second, 5*time.Millisecond)
+
+	shared, err := OpenShared(path)
+	must.NoError(err)
+	again, err := OpenShared(path)
+	must.NoError(err)
+	must.Same(shared, again)
+}
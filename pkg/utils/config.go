@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -17,3 +18,16 @@ func DefaultConfigPath() string {
 	}
 	return filepath.Join(configDir, "keyswift", "config.js")
 }
+
+// DefaultStateDir returns the directory keeping the state between runs
+func DefaultStateDir() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the state directory: %w", err)
+		}
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateDir, "keyswift"), nil
+}
@@ -65,8 +65,8 @@ func (r *Receiver) Close() {
 	r.conn.Close()
 }
 
-// getDBusAddress attempts to get the latest DBus address
-func getDBusAddress() (string, error) {
+// GetDBusAddress attempts to get the latest DBus address of the session bus
+func GetDBusAddress() (string, error) {
 	// 1. First try to get from environment variable
 	if addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); addr != "" {
 		return addr, nil
@@ -122,7 +122,7 @@ func getDBusAddress() (string, error) {
 
 func (r *Receiver) setupDBus() error {
 	// Get the latest DBus address
-	addr, err := getDBusAddress()
+	addr, err := GetDBusAddress()
 	if err != nil {
 		return fmt.Errorf("failed to get DBus address: %w", err)
 	}
@@ -225,3 +225,8 @@ func New(opt ...Option) (wininfo.WinGetter, error) {
 
 	return r, nil
 }
+
+// Conn returns the session bus connection, it's shared with the scripts
+func (r *Receiver) Conn() *dbus.Conn {
+	return r.conn
+}